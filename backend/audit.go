@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+
+	// Values longer than this, usually test data, are recorded by hash
+	maxAuditValueSize = 1024
+)
+
+// AuditFilter selects audit events. Zero fields match everything.
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	EntityType string
+	EntityID   string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+// AuditChange is the before and after value of one field. A side is null
+// when the entity didn't exist.
+type AuditChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// auditDiff compares the JSON form of two versions of an entity field by
+// field. Either may be nil for a create or a delete.
+func auditDiff(before, after interface{}) (map[string]AuditChange, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	null := json.RawMessage("null")
+	diff := make(map[string]AuditChange)
+	for name, value := range beforeFields {
+		if other, ok := afterFields[name]; !ok {
+			diff[name] = AuditChange{Before: value, After: null}
+		} else if !bytes.Equal(value, other) {
+			diff[name] = AuditChange{Before: value, After: other}
+		}
+	}
+	for name, value := range afterFields {
+		if _, ok := beforeFields[name]; !ok {
+			diff[name] = AuditChange{Before: null, After: value}
+		}
+	}
+	return diff, nil
+}
+
+// auditFields returns the top-level JSON fields of an entity, with long
+// values replaced by their size and SHA-256
+func auditFields(entity interface{}) (map[string]json.RawMessage, error) {
+	if entity == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range fields {
+		if len(value) <= maxAuditValueSize {
+			continue
+		}
+		sum := sha256.Sum256(value)
+		summary, _ := json.Marshal(gin.H{"size": len(value), "sha256": hex.EncodeToString(sum[:])})
+		fields[name] = summary
+	}
+	return fields, nil
+}
+
+// audit records that the current user performed action on an entity. The
+// change has already been made, so failing to record it is only logged.
+func (s *Server) audit(c *gin.Context, action, entityType string, entityID interface{}, before, after interface{}) {
+	if err := s.recordAudit(c, action, entityType, entityID, before, after); err != nil {
+		log.Printf("audit: recording %s of %s %v: %v", action, entityType, entityID, err)
+	}
+}
+
+func (s *Server) recordAudit(c *gin.Context, action, entityType string, entityID interface{}, before, after interface{}) error {
+	diff, err := auditDiff(before, after)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(diff)
+	if err != nil {
+		return err
+	}
+	return s.Audit.RecordAuditEvent(AuditEvent{
+		Actor:      currentUser(c).Username,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   auditEntityID(entityID),
+		DiffJSON:   string(data),
+		IP:         c.ClientIP(),
+		CreatedAt:  time.Now(),
+	})
+}
+
+func auditEntityID(id interface{}) string {
+	switch id := id.(type) {
+	case string:
+		return id
+	case uint:
+		return strconv.FormatUint(uint64(id), 10)
+	default:
+		data, _ := json.Marshal(id)
+		return string(data)
+	}
+}
+
+// handleGetAuditLog lists audit events, newest first. Query parameters
+// actor, action, entity_type and entity_id filter exactly; since and until
+// are RFC 3339 times.
+func (s *Server) handleGetAuditLog(c *gin.Context) {
+	filter := AuditFilter{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		EntityType: c.Query("entity_type"),
+		EntityID:   c.Query("entity_id"),
+		Limit:      DefaultAuditLimit,
+	}
+	for param, field := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " time, use RFC 3339"})
+				return
+			}
+			*field = t
+		}
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxAuditLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(MaxAuditLimit)})
+			return
+		}
+		filter.Limit = limit
+	}
+
+	events, err := s.Audit.FindAuditEvents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	type eventResponse struct {
+		AuditEvent
+		DiffJSON string                 `json:"diff_json,omitempty"` // Hides the raw diff, shown parsed as changes
+		Changes  map[string]AuditChange `json:"changes"`
+	}
+	response := make([]eventResponse, len(events))
+	for i, event := range events {
+		response[i].AuditEvent = event
+		json.Unmarshal([]byte(event.DiffJSON), &response[i].Changes)
+	}
+	c.JSON(http.StatusOK, response)
+}
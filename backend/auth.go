@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultSessionTTL is how long a login lasts unless SESSION_TTL overrides it
+const DefaultSessionTTL = 7 * 24 * time.Hour
+
+// contextUserKey is where AuthRequired stores the authenticated User
+const contextUserKey = "user"
+
+// NewSessionToken returns a random opaque token and the hash it is stored
+// under. Only the hash is kept, so a leaked database can't be replayed.
+func NewSessionToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func sessionTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("SESSION_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return DefaultSessionTTL
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// AuthRequired rejects requests without a valid session token and makes the
+// session's user available to the handler through currentUser
+func (s *Server) AuthRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Login required"})
+			return
+		}
+		user, err := s.Users.GetSessionUser(hashToken(token))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session expired or invalid"})
+			return
+		}
+		c.Set(contextUserKey, user)
+		c.Next()
+	}
+}
+
+// sessionUser returns the user of the request's session token, if it has a
+// valid one. For routes open to anonymous users, which don't use
+// AuthRequired.
+func (s *Server) sessionUser(c *gin.Context) (User, bool) {
+	token := bearerToken(c)
+	if token == "" {
+		return User{}, false
+	}
+	user, err := s.Users.GetSessionUser(hashToken(token))
+	return user, err == nil
+}
+
+// currentUser returns the user authenticated by AuthRequired
+func currentUser(c *gin.Context) User {
+	user, _ := c.Get(contextUserKey)
+	return user.(User)
+}
+
+func (s *Server) handleLogin(c *gin.Context) {
+	var body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ok, err := s.Users.VerifyUser(body.Username, body.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		return
+	}
+
+	// Expired sessions are useless; clear them out while logins come in
+	if err := s.Users.DeleteExpiredSessions(); err != nil {
+		log.Printf("auth: deleting expired sessions: %v", err)
+	}
+
+	token, hash, err := NewSessionToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	session := Session{
+		TokenHash: hash,
+		Username:  body.Username,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(sessionTTL()),
+	}
+	if err := s.Users.CreateSession(session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := s.Users.GetSessionUser(hash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "username": user.Username, "role": user.Role, "expires_at": session.ExpiresAt})
+}
+
+func (s *Server) handleLogout(c *gin.Context) {
+	if err := s.Users.DeleteSession(hashToken(bearerToken(c))); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// handleLogoutAll ends every session of the current user, e.g. after a
+// token may have leaked
+func (s *Server) handleLogoutAll(c *gin.Context) {
+	if err := s.Users.DeleteUserSessions(currentUser(c).Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
+}
+
+func handleGetMe(c *gin.Context) {
+	user := currentUser(c)
+	c.JSON(http.StatusOK, gin.H{"username": user.Username, "email": user.Email, "role": user.Role})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore is an S3-style object store. Test data is kept here rather than
+// in the database, keyed by the SHA-256 of its content so identical tests
+// are stored once.
+type BlobStore interface {
+	PutObject(key string, data []byte) error
+	GetObject(key string) ([]byte, error)
+	HasObject(key string) (bool, error)
+}
+
+var Blobs BlobStore
+
+// InitBlobStore opens the blob store. Only the local store exists for now;
+// it lives in BLOB_STORE_DIR (./blobs by default).
+func InitBlobStore() {
+	dir := os.Getenv("BLOB_STORE_DIR")
+	if dir == "" {
+		dir = "blobs"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		panic("error: " + err.Error())
+	}
+	Blobs = &LocalBlobStore{Dir: dir}
+}
+
+// PutBlob stores data and returns its hash
+func PutBlob(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	key := blobKey(hash)
+	exists, err := Blobs.HasObject(key)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if err := Blobs.PutObject(key, data); err != nil {
+			return "", err
+		}
+	}
+	return hash, nil
+}
+
+// GetBlob returns the data stored under hash
+func GetBlob(hash string) ([]byte, error) {
+	return Blobs.GetObject(blobKey(hash))
+}
+
+func blobKey(hash string) string {
+	if len(hash) < 2 {
+		return "sha256/" + hash
+	}
+	return "sha256/" + hash[:2] + "/" + hash
+}
+
+// LocalBlobStore keeps objects as files under Dir, standing in for an
+// S3-compatible bucket
+type LocalBlobStore struct {
+	Dir string
+}
+
+func (s *LocalBlobStore) path(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.Dir, clean), nil
+}
+
+func (s *LocalBlobStore) PutObject(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write then rename so a crash never leaves a truncated object behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalBlobStore) GetObject(key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("blob %s: %v", key, err)
+	}
+	return data, nil
+}
+
+func (s *LocalBlobStore) HasObject(key string) (bool, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -10,6 +10,7 @@ type LeaderboardBroker struct {
 	// Map contestID -> list of client channels
 	Clients map[uint][]chan string
 	Lock    sync.Mutex
+	Buffer  int // Messages buffered per client, 5 if unset
 }
 
 var Broker *LeaderboardBroker
@@ -18,13 +19,21 @@ func InitBroker() {
 	Broker = &LeaderboardBroker{
 		Clients: make(map[uint][]chan string),
 	}
+	SubmissionEvents = &LeaderboardBroker{
+		Clients: make(map[uint][]chan string),
+		Buffer:  64, // One event per test; don't drop "finished" behind them
+	}
 }
 
 func (b *LeaderboardBroker) Subscribe(contestID uint) chan string {
 	b.Lock.Lock()
 	defer b.Lock.Unlock()
 
-	ch := make(chan string, 5) // Buffer slightly
+	buffer := b.Buffer
+	if buffer == 0 {
+		buffer = 5 // Buffer slightly
+	}
+	ch := make(chan string, buffer)
 	b.Clients[contestID] = append(b.Clients[contestID], ch)
 	return ch
 }
@@ -55,11 +64,16 @@ func (b *LeaderboardBroker) Broadcast(contestID uint, data interface{}) {
 	
 	msg := string(jsonData)
 
+	clients := b.Clients[contestID][:0]
 	for _, ch := range b.Clients[contestID] {
 		select {
 		case ch <- msg:
+			clients = append(clients, ch)
 		default:
-			// Client channel full, skip or drop
+			// The client can't keep up. Disconnect it rather than drop a
+			// message it would never know it missed.
+			close(ch)
 		}
 	}
+	b.Clients[contestID] = clients
 }
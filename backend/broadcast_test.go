@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBroadcastDisconnectsSlowClients(t *testing.T) {
+	broker := &LeaderboardBroker{Clients: make(map[uint][]chan string), Buffer: 1}
+	slow := broker.Subscribe(1)
+	fast := broker.Subscribe(1)
+
+	broker.Broadcast(1, "first")
+	<-fast
+	broker.Broadcast(1, "second")
+
+	if msg := <-slow; msg != `"first"` {
+		t.Errorf("slow client got %s, want the first message", msg)
+	}
+	if _, ok := <-slow; ok {
+		t.Error("slow client is still subscribed after missing a message")
+	}
+	if msg := <-fast; msg != `"second"` {
+		t.Errorf("fast client got %s, want the second message", msg)
+	}
+	// Unsubscribing a client the broker dropped must not close it twice
+	broker.Unsubscribe(1, slow)
+	broker.Unsubscribe(1, fast)
+}
+
+// A submission still Queued that no worker knows about, e.g. after a
+// restart, must not leave its stream hanging
+func TestSubmissionStreamEndsForLostSubmission(t *testing.T) {
+	InitBroker()
+	stores := NewMemoryStores()
+	InitQueue(1, stores.Submissions)
+	t.Cleanup(Queue.Shutdown)
+
+	submission := Submission{UserID: "alice", Language: "python", Status: StatusQueued}
+	if err := stores.Submissions.CreateSubmission(&submission); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/submission/1/events", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	NewServer(stores).Router().ServeHTTP(w, req)
+
+	if ctx.Err() != nil {
+		t.Fatal("stream never ended")
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"event":"finished"`) || !strings.Contains(body, `"status":"`+StatusError+`"`) {
+		t.Errorf("stream %q, want a finished event with Error", body)
+	}
+}
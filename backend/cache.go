@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// verdictCacheKey identifies a judging run: the same source in the same
+// language against the same tests, limits and sandbox policy always gets the
+// same verdict. Any change to the tests changes the key, so stale entries
+// are never hit; they are also deleted when a problem's tests are replaced.
+func verdictCacheKey(problem Problem, lang Language, source string) string {
+	data, _ := json.Marshal(struct {
+		Language Language
+		Limits   Limits
+		Policy   SandboxPolicy
+		Tests    [5]string
+		Source   string
+	}{
+		Language: lang,
+		Limits:   problemLimits(problem),
+		Policy:   Policy,
+		Tests:    [5]string{problem.SignatureJSON, problem.RunnerCode, problem.Input, problem.Output, problem.TestCasesJSON},
+		Source:   source,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheableStatus reports whether a verdict is a property of the code alone.
+// Time limits depend on how loaded the machine was and errors on the judge.
+func cacheableStatus(status string) bool {
+	switch status {
+	case StatusTimeLimitExceeded, StatusError, StatusCanceled, StatusQueued:
+		return false
+	}
+	return true
+}
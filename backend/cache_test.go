@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestVerdictCacheKey(t *testing.T) {
+	python := Language{ID: "python", SourceFile: "solution.py", RunCommand: "python solution.py"}
+	key := verdictCacheKey(testIOProblem, python, "print(1)")
+	if verdictCacheKey(testIOProblem, python, "print(1)") != key {
+		t.Fatal("the same run got two keys")
+	}
+
+	otherTests := testIOProblem
+	otherTests.TestCasesJSON = `[{"input":"1","output":"2"}]`
+	otherLimit := testIOProblem
+	otherLimit.OutputLimitKB = 1
+	renamed := testIOProblem
+	renamed.Title = "Echo"
+	for name, other := range map[string]string{
+		"source":       verdictCacheKey(testIOProblem, python, "print(2)"),
+		"language":     verdictCacheKey(testIOProblem, testCompiledLanguage, "print(1)"),
+		"tests":        verdictCacheKey(otherTests, python, "print(1)"),
+		"output limit": verdictCacheKey(otherLimit, python, "print(1)"),
+	} {
+		if other == key {
+			t.Errorf("a different %s got the same key", name)
+		}
+	}
+	if verdictCacheKey(renamed, python, "print(1)") != key {
+		t.Error("a change that can't affect the verdict changed the key")
+	}
+}
+
+func TestCachedVerdictsDroppedWithTests(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		problem := Problem{Title: "Echo", Input: "1\n", Output: "1\n"}
+		if err := stores.Problems.CreateProblem(&problem, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		cached := func(key string) bool {
+			t.Helper()
+			_, ok, err := stores.Submissions.GetCachedVerdict(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return ok
+		}
+		save := func(key string) {
+			t.Helper()
+			if err := stores.Submissions.SaveCachedVerdict(key, problem.ID, Verdict{Status: StatusPassed, PassedCount: 1, TotalCount: 1}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		save("a")
+		if verdict, ok, err := stores.Submissions.GetCachedVerdict("a"); !ok || err != nil || verdict.Status != StatusPassed {
+			t.Fatalf("cached verdict %+v, %v, %v", verdict, ok, err)
+		}
+		// An update leaving the tests out keeps them, and the cache
+		problem.Title = "Echo again"
+		problem.Input, problem.Output = "", ""
+		if err := stores.Problems.UpdateProblem(problem, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		if !cached("a") {
+			t.Error("a metadata update dropped the cached verdicts")
+		}
+		problem.Input, problem.Output = "2\n", "2\n"
+		if err := stores.Problems.UpdateProblem(problem, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		if cached("a") {
+			t.Error("new tests kept the cached verdicts")
+		}
+
+		save("b")
+		if err := stores.Problems.DeleteProblem(problem.ID); err != nil {
+			t.Fatal(err)
+		}
+		if cached("b") {
+			t.Error("deleting the problem kept its cached verdicts")
+		}
+	})
+}
+
+// Resubmitting the same code is answered from the cache, and rejudges
+// always run
+func TestQueueReusesCachedVerdicts(t *testing.T) {
+	executor := &fakeExecutor{run: echoRun}
+	useExecutor(t, executor)
+	Languages = []Language{{ID: "python", SourceFile: "solution.py", RunCommand: "python solution.py"}}
+	InitBroker()
+	stores := NewMemoryStores()
+	InitQueue(1, stores.Submissions)
+	t.Cleanup(Queue.Shutdown)
+
+	judge := func(source string, rejudge bool) Verdict {
+		t.Helper()
+		submission := Submission{UserID: "alice", Language: "python", Source: source, Status: StatusQueued}
+		if err := stores.Submissions.CreateSubmission(&submission); err != nil {
+			t.Fatal(err)
+		}
+		outcome := awaitOutcome(t, Queue.Submit(context.Background(), submission, testIOProblem, rejudge))
+		if outcome.Err != nil {
+			t.Fatal(outcome.Err)
+		}
+		return outcome.Verdict
+	}
+	runs := func() int {
+		executor.mu.Lock()
+		defer executor.mu.Unlock()
+		return len(executor.specs)
+	}
+
+	first := judge("print(input())", false)
+	if first.Status != StatusPassed {
+		t.Fatalf("verdict %+v, want Passed", first)
+	}
+	before := runs()
+	if again := judge("print(input())", false); again.Status != first.Status || again.PassedCount != first.PassedCount {
+		t.Errorf("resubmission got %+v, want the cached %+v", again, first)
+	}
+	if runs() != before {
+		t.Errorf("resubmission ran %d times in the sandbox", runs()-before)
+	}
+
+	judge("print(input())", true)
+	if runs() == before {
+		t.Error("rejudge was answered from the cache")
+	}
+	before = runs()
+	judge("print(input() )", false)
+	if runs() == before {
+		t.Error("different source was answered from the cache")
+	}
+}
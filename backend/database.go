@@ -1,46 +1,115 @@
 package main
 
 import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
 	"sort"
+	"strings"
 	"time"
 
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	_ "modernc.org/sqlite"
 )
 
-var DB *gorm.DB
+// GormStore implements every store on a SQL database through GORM
+type GormStore struct {
+	db *gorm.DB
+}
 
-func InitDatabase() {
-	database, err := gorm.Open(
-		&sqlite.Dialector{
-			DriverName: "sqlite",
-			DSN:        "users.db",
-		},
-		&gorm.Config{},
-	)
-	if err != nil {
-		panic("error: " + err.Error())
+// NewGormStores returns stores backed by an open, migrated database
+func NewGormStores(db *gorm.DB) Stores {
+	store := &GormStore{db: db}
+	return Stores{
+		Users:         store,
+		Contests:      store,
+		Problems:      store,
+		Registrations: store,
+		Submissions:   store,
+		Audit:         store,
+	}
+}
+
+// openDatabase connects to the database selected by DB_DRIVER: "sqlite"
+// (default) or "postgres". DB_DSN is the SQLite file, users.db by default,
+// or a PostgreSQL connection string.
+func openDatabase() (*gorm.DB, error) {
+	dsn := os.Getenv("DB_DSN")
+	switch driver := os.Getenv("DB_DRIVER"); driver {
+	case "", "sqlite":
+		if dsn == "" {
+			dsn = "users.db"
+		}
+		return gorm.Open(
+			&sqlite.Dialector{
+				DriverName: "sqlite",
+				DSN:        sqliteDSN(dsn),
+			},
+			&gorm.Config{},
+		)
+	case "postgres":
+		if dsn == "" {
+			return nil, fmt.Errorf("DB_DSN must be set for the postgres driver")
+		}
+		return gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q", driver)
 	}
-	database.AutoMigrate(&User{}, &Contest{}, &Problem{}, &Registration{}, &Submission{})
-	DB = database
 }
 
-func CreateUser(user User) error {
-	result := DB.Create(&user)
-	return result.Error
+// sqliteDSN adds the pragmas every SQLite connection needs: WAL so readers
+// don't block the judge's writes, and a busy timeout so concurrent writers
+// wait for each other instead of failing with "database is locked". Times
+// are written in SQLite's own format rather than as time.Time.String, whose
+// monotonic clock reading keeps a stored time from ever equalling one read
+// back, which paging by a time column relies on.
+func sqliteDSN(dsn string) string {
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + "_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+}
+
+func (s *GormStore) CreateUser(user User) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&User{}).Where("username = ?", user.Username).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrUserExists
+		}
+		return tx.Create(&user).Error
+	})
+}
+
+func (s *GormStore) ListUsers(filter UserFilter, opts ListOptions) ([]User, Page, error) {
+	query := s.db.Model(&User{})
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	if filter.Query != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(filter.Query)) + "%"
+		query = query.Where("(LOWER(username) LIKE ? ESCAPE '\\' OR LOWER(email) LIKE ? ESCAPE '\\')", pattern, pattern)
+	}
+	return findPage(query, opts, userOrder)
 }
 
-func GetAllUsers() ([]User, error) {
-	var users []User
-	err := DB.Find(&users).Error
-	return users, err
+func (s *GormStore) GetUser(username string) (User, error) {
+	var user User
+	err := s.db.Where("username = ?", username).First(&user).Error
+	return user, err
 }
 
-func UserExists(username string) (bool, error) {
+func (s *GormStore) UserExists(username string) (bool, error) {
 	var user User
-	result := DB.Where("username = ?", username).First(&user)
+	result := s.db.Where("username = ?", username).First(&user)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			return false, nil
@@ -50,89 +119,545 @@ func UserExists(username string) (bool, error) {
 	return true, nil
 }
 
-func VerifyUser(username, password string) (bool, error) {
+// VerifyUser checks a user's password. A password still stored in
+// plaintext is replaced by its hash on the first successful check.
+func (s *GormStore) VerifyUser(username, password string) (bool, error) {
 	var user User
-	result := DB.Where("username = ? AND password = ?", username, password).First(&user)
+	result := s.db.Where("username = ?", username).First(&user)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			return false, nil
 		}
 		return false, result.Error
 	}
+
+	if isPasswordHash(user.Password) {
+		return CheckPassword(user.Password, password), nil
+	}
+	if subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+		return false, nil
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return false, err
+	}
+	if err := s.db.Model(&User{}).Where("username = ?", username).Update("password", hash).Error; err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
-func CreateContest(contest Contest) error {
-	return DB.Create(&contest).Error
+func (s *GormStore) CreateSession(session Session) error {
+	return s.db.Create(&session).Error
+}
+
+// GetSessionUser returns the user logged in with a session, if it hasn't expired
+func (s *GormStore) GetSessionUser(tokenHash string) (User, error) {
+	var session Session
+	if err := s.db.Where("token_hash = ? AND expires_at > ?", tokenHash, time.Now()).First(&session).Error; err != nil {
+		return User{}, err
+	}
+	var user User
+	err := s.db.Where("username = ?", session.Username).First(&user).Error
+	return user, err
 }
 
-func UpdateContest(contest Contest) error {
-	return DB.Save(&contest).Error
+func (s *GormStore) DeleteSession(tokenHash string) error {
+	return s.db.Where("token_hash = ?", tokenHash).Delete(&Session{}).Error
 }
 
-func DeleteContest(id uint) error {
+func (s *GormStore) DeleteUserSessions(username string) error {
+	return s.db.Where("username = ?", username).Delete(&Session{}).Error
+}
+
+func (s *GormStore) DeleteExpiredSessions() error {
+	return s.db.Where("expires_at <= ?", time.Now()).Delete(&Session{}).Error
+}
+
+// Problems join or leave a contest through UpdateProblem, where the
+// permission checks and revisions are, never with the contest itself
+func (s *GormStore) CreateContest(contest *Contest) error {
+	return s.db.Omit(clause.Associations).Create(contest).Error
+}
+
+func (s *GormStore) UpdateContest(contest Contest) error {
+	return s.db.Omit(clause.Associations).Save(&contest).Error
+}
+
+func (s *GormStore) DeleteContest(id uint) error {
 	// Unlink problems first (make them practice problems)
-	if err := DB.Model(&Problem{}).Where("contest_id = ?", id).Update("contest_id", 0).Error; err != nil {
+	if err := s.db.Model(&Problem{}).Where("contest_id = ?", id).Update("contest_id", 0).Error; err != nil {
 		return err
 	}
-	return DB.Delete(&Contest{}, id).Error
+	if err := s.db.Where("contest_id = ?", id).Delete(&ContestStaff{}).Error; err != nil {
+		return err
+	}
+	return s.db.Delete(&Contest{}, id).Error
 }
 
-func GetContests() ([]Contest, error) {
-	var contests []Contest
-	err := DB.Find(&contests).Error
-	return contests, err
+func (s *GormStore) SetUserRole(username, role string) error {
+	return s.db.Model(&User{}).Where("username = ?", username).Update("role", role).Error
+}
+
+func (s *GormStore) AddContestStaff(contestID uint, username string) error {
+	staff := ContestStaff{ContestID: contestID, Username: username}
+	return s.db.Where(staff).FirstOrCreate(&staff).Error
+}
+
+func (s *GormStore) RemoveContestStaff(contestID uint, username string) error {
+	return s.db.Where("contest_id = ? AND username = ?", contestID, username).Delete(&ContestStaff{}).Error
 }
 
-func CreateProblem(problem Problem) error {
-	return DB.Create(&problem).Error
+func (s *GormStore) GetContestStaff(contestID uint) ([]ContestStaff, error) {
+	var staff []ContestStaff
+	err := s.db.Where("contest_id = ?", contestID).Find(&staff).Error
+	return staff, err
 }
 
-func GetContestByID(id uint) (Contest, error) {
+func (s *GormStore) IsContestStaff(contestID uint, username string) (bool, error) {
+	var count int64
+	err := s.db.Model(&ContestStaff{}).Where("contest_id = ? AND username = ?", contestID, username).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *GormStore) ListContests(filter ContestFilter, opts ListOptions) ([]Contest, Page, error) {
+	query := s.db.Model(&Contest{})
+	now := time.Now()
+	switch filter.Status {
+	case "upcoming":
+		query = query.Where("start_time > ?", now)
+	case "active":
+		query = query.Where("start_time <= ? AND end_time >= ?", now, now)
+	case "ended":
+		query = query.Where("end_time < ?", now)
+	}
+	return findPage(query, opts, contestOrder)
+}
+
+func (s *GormStore) CreateProblem(problem *Problem, author string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		problem.Revision = 1
+		if err := tx.Create(problem).Error; err != nil {
+			return err
+		}
+		if err := saveProblemTags(tx, problem.ID, problem.Tags); err != nil {
+			return err
+		}
+		tests, err := saveProblemTests(tx, *problem)
+		if err != nil {
+			return err
+		}
+		return createRevision(tx, *problem, tests, author)
+	})
+}
+
+func (s *GormStore) GetContestByID(id uint) (Contest, error) {
 	var contest Contest
-	err := DB.Preload("Problems").First(&contest, id).Error
+	omitHidden := func(db *gorm.DB) *gorm.DB { return db.Omit(hiddenProblemColumns...) }
+	if err := s.db.Preload("Problems", omitHidden).First(&contest, id).Error; err != nil {
+		return contest, err
+	}
+	err := loadProblemTags(s.db, contest.Problems)
 	return contest, err
 }
 
-func GetProblemByID(id uint) (Problem, error) {
+func (s *GormStore) GetProblemByID(id uint) (Problem, error) {
 	var problem Problem
-	err := DB.First(&problem, id).Error
+	if err := s.db.First(&problem, id).Error; err != nil {
+		return problem, err
+	}
+	problems := []Problem{problem}
+	if err := loadProblemTags(s.db, problems); err != nil {
+		return problem, err
+	}
+	problem = problems[0]
+	err := s.loadProblemTests(&problem)
 	return problem, err
 }
 
-func GetPracticeProblems() ([]Problem, error) {
-	var problems []Problem
-	err := DB.Where("contest_id = ?", 0).Find(&problems).Error
-	return problems, err
+func (s *GormStore) ListProblems(filter ProblemFilter, opts ListOptions) ([]Problem, Page, error) {
+	problems, page, err := findPage(s.problemQuery(filter).Omit(hiddenProblemColumns...), opts, problemOrder)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	err = loadProblemTags(s.db, problems)
+	return problems, page, err
 }
 
-func GetAllProblems() ([]Problem, error) {
-	var problems []Problem
-	err := DB.Find(&problems).Error
-	return problems, err
+// hiddenProblemColumns hold the code of a problem that lists, which anyone
+// may read, leave out
+var hiddenProblemColumns = []string{"validator", "runner_code", "templates_json"}
+
+// problemQuery selects the problems matching filter
+func (s *GormStore) problemQuery(filter ProblemFilter) *gorm.DB {
+	query := s.db.Model(&Problem{})
+	if filter.Practice {
+		query = query.Where("problems.contest_id = ?", 0)
+	}
+	if filter.ContestID != 0 {
+		query = query.Where("problems.contest_id = ?", filter.ContestID)
+	}
+	if filter.Difficulty != "" {
+		query = query.Where("LOWER(problems.difficulty) = ?", strings.ToLower(filter.Difficulty))
+	}
+	for _, tag := range filter.Tags {
+		query = query.Where("problems.id IN (SELECT problem_id FROM problem_tags WHERE tag = ?)", tag)
+	}
+	if filter.MinPoints != nil {
+		query = query.Where("problems.points >= ?", *filter.MinPoints)
+	}
+	if filter.MaxPoints != nil {
+		query = query.Where("problems.points <= ?", *filter.MaxPoints)
+	}
+	if filter.SolvedBy != "" {
+		query = query.Where("problems.id IN (SELECT problem_id FROM submissions WHERE user_id = ? AND status = ?)", filter.SolvedBy, StatusPassed)
+	}
+	if filter.UnsolvedBy != "" {
+		query = query.Where("problems.id NOT IN (SELECT problem_id FROM submissions WHERE user_id = ? AND status = ?)", filter.UnsolvedBy, StatusPassed)
+	}
+	if len(searchTerms(filter.Query)) > 0 {
+		query = s.textSearch(query, filter.Query)
+	}
+	return query
 }
 
-func UpdateProblem(problem Problem) error {
-	return DB.Save(&problem).Error
+// likeEscaper escapes the LIKE wildcards in user input, for ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// findPage counts the rows query matches, then reads the page of them opts
+// selects in order
+func findPage[T any](query *gorm.DB, opts ListOptions, order listOrder[T]) ([]T, Page, error) {
+	key, err := order.key(opts.Sort)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	query = query.Session(&gorm.Session{})
+
+	var page Page
+	if err := query.Count(&page.Total).Error; err != nil {
+		return nil, Page{}, err
+	}
+	column, tiebreak := key.Column, order.Tiebreak.Column
+	direction, past := "", ">"
+	if opts.Desc {
+		direction, past = " DESC", "<"
+	}
+	if opts.After != nil {
+		query = query.Where("("+column+" "+past+" ? OR ("+column+" = ? AND "+tiebreak+" > ?))", opts.After[0], opts.After[0], opts.After[1])
+	}
+	query = query.Order(column + direction).Order(tiebreak)
+	if opts.Limit > 0 {
+		query = query.Limit(opts.Limit + 1)
+	}
+	var items []T
+	if err := query.Find(&items).Error; err != nil {
+		return nil, Page{}, err
+	}
+	items, page.Next = nextPage(items, opts, key, order.Tiebreak)
+	return items, page, nil
 }
 
-func DeleteProblem(id uint) error {
-	return DB.Delete(&Problem{}, id).Error
+// postgresSearchVector is the expression of the full-text index migration 5
+// creates on PostgreSQL. Queries must use it verbatim to use the index.
+const postgresSearchVector = "to_tsvector('english', COALESCE(title, '') || ' ' || COALESCE(description, ''))"
+
+// textSearch restricts query to problems whose title or statement contain
+// every word of text, using the index migration 5 built for the database
+func (s *GormStore) textSearch(query *gorm.DB, text string) *gorm.DB {
+	if s.db.Dialector.Name() == "postgres" {
+		return query.Where(postgresSearchVector+" @@ plainto_tsquery('english', ?)", text)
+	}
+	return query.Where("problems.id IN (SELECT rowid FROM problems_fts WHERE problems_fts MATCH ?)", ftsMatch(text))
 }
 
-func RegisterForContest(userID string, contestID uint, extraInfo string) error {
+// UpdateProblem saves a problem as a new revision. Its tests are only
+// replaced if it carries any, so editing a problem fetched from a list
+// keeps them.
+func (s *GormStore) UpdateProblem(problem Problem, author string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		revision, err := nextRevision(tx, problem.ID)
+		if err != nil {
+			return err
+		}
+		problem.Revision = revision
+		if err := tx.Save(&problem).Error; err != nil {
+			return err
+		}
+		if err := saveProblemTags(tx, problem.ID, problem.Tags); err != nil {
+			return err
+		}
+		var tests []RevisionTest
+		if hasTestData(problem) {
+			tests, err = saveProblemTests(tx, problem)
+		} else {
+			tests, err = currentTests(tx, problem.ID)
+		}
+		if err != nil {
+			return err
+		}
+		return createRevision(tx, problem, tests, author)
+	})
+}
+
+func (s *GormStore) DeleteProblem(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("problem_id = ?", id).Delete(&TestCase{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("problem_id = ?", id).Delete(&CachedVerdict{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("problem_id = ?", id).Delete(&ProblemTag{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Problem{}, id).Error
+	})
+}
+
+func (s *GormStore) GetProblemRevisions(problemID uint) ([]ProblemRevision, error) {
+	var revisions []ProblemRevision
+	err := s.db.Where("problem_id = ?", problemID).Order("revision").Find(&revisions).Error
+	return revisions, err
+}
+
+func (s *GormStore) GetProblemRevision(problemID uint, revision int) (Problem, error) {
+	problem, tests, err := readRevision(s.db, problemID, revision)
+	if err != nil {
+		return problem, err
+	}
+	err = loadTestBlobs(&problem, tests)
+	return problem, err
+}
+
+// RollbackProblem makes an old revision current again by saving a copy of
+// it as a new revision; history is never rewritten
+func (s *GormStore) RollbackProblem(problemID uint, revision int, author string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		problem, tests, err := readRevision(tx, problemID, revision)
+		if err != nil {
+			return err
+		}
+		var current Problem
+		if err := tx.Select("id", "contest_id", "owner_id").First(&current, problemID).Error; err != nil {
+			return err
+		}
+		// Moving a problem is checked separately from editing it
+		problem.ContestID, problem.OwnerID = current.ContestID, current.OwnerID
+		if problem.Revision, err = nextRevision(tx, problemID); err != nil {
+			return err
+		}
+		if err := tx.Save(&problem).Error; err != nil {
+			return err
+		}
+		if err := saveProblemTags(tx, problemID, problem.Tags); err != nil {
+			return err
+		}
+		if err := replaceTestRows(tx, problemID, tests); err != nil {
+			return err
+		}
+		return createRevision(tx, problem, tests, author)
+	})
+}
+
+// saveProblemTags replaces the tags of a problem
+func saveProblemTags(tx *gorm.DB, problemID uint, tags []string) error {
+	if err := tx.Where("problem_id = ?", problemID).Delete(&ProblemTag{}).Error; err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	rows := make([]ProblemTag, len(tags))
+	for i, tag := range tags {
+		rows[i] = ProblemTag{ProblemID: problemID, Tag: tag}
+	}
+	return tx.Create(&rows).Error
+}
+
+// loadProblemTags fills in the tags of problems with a single query
+func loadProblemTags(db *gorm.DB, problems []Problem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(problems))
+	for i, problem := range problems {
+		ids[i] = problem.ID
+	}
+	var rows []ProblemTag
+	if err := db.Where("problem_id IN ?", ids).Order("tag").Find(&rows).Error; err != nil {
+		return err
+	}
+	tags := make(map[uint][]string)
+	for _, row := range rows {
+		tags[row.ProblemID] = append(tags[row.ProblemID], row.Tag)
+	}
+	for i := range problems {
+		problems[i].Tags = tags[problems[i].ID]
+		if problems[i].Tags == nil {
+			problems[i].Tags = []string{}
+		}
+	}
+	return nil
+}
+
+func nextRevision(tx *gorm.DB, problemID uint) (int, error) {
+	var last int
+	err := tx.Model(&ProblemRevision{}).Where("problem_id = ?", problemID).
+		Select("COALESCE(MAX(revision), 0)").Scan(&last).Error
+	return last + 1, err
+}
+
+// createRevision records problem, as just saved with its Revision set, and
+// its tests as an immutable revision
+func createRevision(tx *gorm.DB, problem Problem, tests []RevisionTest, author string) error {
+	problem.Input, problem.Output, problem.TestCasesJSON = "", "", ""
+	problemJSON, err := json.Marshal(problem)
+	if err != nil {
+		return err
+	}
+	testsJSON, err := json.Marshal(tests)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&ProblemRevision{
+		ProblemID:   problem.ID,
+		Revision:    problem.Revision,
+		Author:      author,
+		CreatedAt:   time.Now(),
+		ProblemJSON: string(problemJSON),
+		TestsJSON:   string(testsJSON),
+		TestCount:   len(tests),
+	}).Error
+}
+
+// readRevision returns a revision of a problem, without loading its tests
+func readRevision(tx *gorm.DB, problemID uint, revision int) (Problem, []RevisionTest, error) {
+	var problem Problem
+	var row ProblemRevision
+	if err := tx.Where("problem_id = ? AND revision = ?", problemID, revision).First(&row).Error; err != nil {
+		return problem, nil, err
+	}
+	if err := json.Unmarshal([]byte(row.ProblemJSON), &problem); err != nil {
+		return problem, nil, fmt.Errorf("revision %d: %v", revision, err)
+	}
+	var tests []RevisionTest
+	if err := json.Unmarshal([]byte(row.TestsJSON), &tests); err != nil {
+		return problem, nil, fmt.Errorf("revision %d: %v", revision, err)
+	}
+	problem.ID, problem.Revision = problemID, revision
+	return problem, tests, nil
+}
+
+// saveProblemTests writes a problem's tests to the blob store and replaces
+// its test case rows
+func saveProblemTests(tx *gorm.DB, problem Problem) ([]RevisionTest, error) {
+	files, err := problemTestFiles(problem)
+	if err != nil {
+		return nil, err
+	}
+	tests := make([]RevisionTest, len(files))
+	for i, test := range files {
+		inputHash, err := PutBlob([]byte(test.Input))
+		if err != nil {
+			return nil, err
+		}
+		outputHash, err := PutBlob([]byte(test.Output))
+		if err != nil {
+			return nil, err
+		}
+		tests[i] = RevisionTest{
+			InputHash:  inputHash,
+			OutputHash: outputHash,
+			InputSize:  len(test.Input),
+			OutputSize: len(test.Output),
+		}
+	}
+	return tests, replaceTestRows(tx, problem.ID, tests)
+}
+
+// replaceTestRows points a problem's test case rows at tests already in the
+// blob store
+func replaceTestRows(tx *gorm.DB, problemID uint, tests []RevisionTest) error {
+	if err := tx.Where("problem_id = ?", problemID).Delete(&TestCase{}).Error; err != nil {
+		return err
+	}
+	// Verdicts against the old tests will never be looked up again
+	if err := tx.Where("problem_id = ?", problemID).Delete(&CachedVerdict{}).Error; err != nil {
+		return err
+	}
+	if len(tests) == 0 {
+		return nil
+	}
+	rows := make([]TestCase, len(tests))
+	for i, test := range tests {
+		rows[i] = TestCase{
+			ProblemID:  problemID,
+			Position:   i + 1,
+			InputHash:  test.InputHash,
+			OutputHash: test.OutputHash,
+			InputSize:  test.InputSize,
+			OutputSize: test.OutputSize,
+		}
+	}
+	return tx.Create(&rows).Error
+}
+
+// currentTests returns the blob references of a problem's current tests
+func currentTests(tx *gorm.DB, problemID uint) ([]RevisionTest, error) {
+	var rows []TestCase
+	if err := tx.Where("problem_id = ?", problemID).Order("position").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	tests := make([]RevisionTest, len(rows))
+	for i, row := range rows {
+		tests[i] = RevisionTest{
+			InputHash:  row.InputHash,
+			OutputHash: row.OutputHash,
+			InputSize:  row.InputSize,
+			OutputSize: row.OutputSize,
+		}
+	}
+	return tests, nil
+}
+
+// loadProblemTests fills in a problem's test data from the blob store
+func (s *GormStore) loadProblemTests(problem *Problem) error {
+	tests, err := currentTests(s.db, problem.ID)
+	if err != nil {
+		return err
+	}
+	return loadTestBlobs(problem, tests)
+}
+
+func loadTestBlobs(problem *Problem, tests []RevisionTest) error {
+	files := make([]IOTest, len(tests))
+	for i, test := range tests {
+		input, err := GetBlob(test.InputHash)
+		if err != nil {
+			return err
+		}
+		output, err := GetBlob(test.OutputHash)
+		if err != nil {
+			return err
+		}
+		files[i] = IOTest{Input: string(input), Output: string(output)}
+	}
+	return setProblemTestFiles(problem, files)
+}
+
+func (s *GormStore) RegisterForContest(userID string, contestID uint, extraInfo string) error {
 	registration := Registration{
 		UserID:       userID,
 		ContestID:    contestID,
 		RegisteredAt: time.Now(),
 		ExtraInfo:    extraInfo,
 	}
-	return DB.Create(&registration).Error
+	return s.db.Create(&registration).Error
 }
 
-func IsUserRegistered(userID string, contestID uint) (bool, error) {
+func (s *GormStore) IsUserRegistered(userID string, contestID uint) (bool, error) {
 	var registration Registration
-	err := DB.Where("user_id = ? AND contest_id = ?", userID, contestID).First(&registration).Error
+	err := s.db.Where("user_id = ? AND contest_id = ?", userID, contestID).First(&registration).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return false, nil
@@ -142,26 +667,149 @@ func IsUserRegistered(userID string, contestID uint) (bool, error) {
 	return true, nil
 }
 
-func GetContestRegistrationsCount(contestID uint) (int64, error) {
+func (s *GormStore) GetContestRegistrationsCount(contestID uint) (int64, error) {
 	var count int64
-	err := DB.Model(&Registration{}).Where("contest_id = ?", contestID).Count(&count).Error
+	err := s.db.Model(&Registration{}).Where("contest_id = ?", contestID).Count(&count).Error
 	return count, err
 }
 
-func GetContestRegistrations(contestID uint) ([]Registration, error) {
-	var registrations []Registration
-	err := DB.Where("contest_id = ?", contestID).Find(&registrations).Error
-	return registrations, err
+// CountContestRegistrations counts the registrations of several contests in
+// one query. Contests nobody registered for are left out.
+func (s *GormStore) CountContestRegistrations(contestIDs []uint) (map[uint]int64, error) {
+	counts := make(map[uint]int64)
+	if len(contestIDs) == 0 {
+		return counts, nil
+	}
+	var rows []struct {
+		ContestID uint
+		Count     int64
+	}
+	err := s.db.Model(&Registration{}).
+		Select("contest_id, COUNT(*) AS count").
+		Where("contest_id IN ?", contestIDs).
+		Group("contest_id").
+		Scan(&rows).Error
+	for _, row := range rows {
+		counts[row.ContestID] = row.Count
+	}
+	return counts, err
+}
+
+func (s *GormStore) ListContestRegistrations(contestID uint, opts ListOptions) ([]Registration, Page, error) {
+	return findPage(s.db.Model(&Registration{}).Where("contest_id = ?", contestID), opts, registrationOrder)
 }
 
-func GetUserRegistrations(userID string) ([]Registration, error) {
+// GetRegisteredContests returns the contests each of several users
+// registered for, in one query
+func (s *GormStore) GetRegisteredContests(userIDs []string) (map[string][]uint, error) {
+	contests := make(map[string][]uint)
+	if len(userIDs) == 0 {
+		return contests, nil
+	}
 	var registrations []Registration
-	err := DB.Where("user_id = ?", userID).Find(&registrations).Error
-	return registrations, err
+	err := s.db.Select("user_id, contest_id").Where("user_id IN ?", userIDs).Order("id").Find(&registrations).Error
+	for _, registration := range registrations {
+		contests[registration.UserID] = append(contests[registration.UserID], registration.ContestID)
+	}
+	return contests, err
+}
+
+// GetCachedVerdict returns the cached verdict for key, if any
+func (s *GormStore) GetCachedVerdict(key string) (Verdict, bool, error) {
+	var cached CachedVerdict
+	err := s.db.Where("key = ?", key).Limit(1).Find(&cached).Error
+	if err != nil || cached.Key == "" {
+		return Verdict{}, false, err
+	}
+	var verdict Verdict
+	if err := json.Unmarshal([]byte(cached.VerdictJSON), &verdict); err != nil {
+		return Verdict{}, false, err
+	}
+	return verdict, true, nil
+}
+
+func (s *GormStore) SaveCachedVerdict(key string, problemID uint, verdict Verdict) error {
+	data, err := json.Marshal(verdict)
+	if err != nil {
+		return err
+	}
+	return s.db.Save(&CachedVerdict{
+		Key:         key,
+		ProblemID:   problemID,
+		VerdictJSON: string(data),
+		CreatedAt:   time.Now(),
+	}).Error
+}
+
+func (s *GormStore) CreateSubmission(submission *Submission) error {
+	return s.db.Create(submission).Error
+}
+
+func (s *GormStore) UpdateSubmission(submission Submission) error {
+	return s.db.Save(&submission).Error
+}
+
+func (s *GormStore) GetSubmissionByID(id uint) (Submission, error) {
+	var submission Submission
+	err := s.db.First(&submission, id).Error
+	return submission, err
+}
+
+// FindSubmissions returns the submissions matching every non-zero field of
+// the filter, oldest first
+func (s *GormStore) FindSubmissions(filter SubmissionFilter) ([]Submission, error) {
+	query := s.db.Model(&Submission{})
+	if filter.SubmissionID != 0 {
+		query = query.Where("submissions.id = ?", filter.SubmissionID)
+	}
+	if filter.ProblemID != 0 {
+		query = query.Where("submissions.problem_id = ?", filter.ProblemID)
+	}
+	if filter.UserID != "" {
+		query = query.Where("submissions.user_id = ?", filter.UserID)
+	}
+	if filter.ContestID != 0 {
+		query = query.Joins("JOIN problems ON problems.id = submissions.problem_id").
+			Where("problems.contest_id = ?", filter.ContestID)
+	}
+
+	var submissions []Submission
+	err := query.Order("submissions.id asc").Find(&submissions).Error
+	return submissions, err
 }
 
-func CreateSubmission(submission Submission) error {
-	return DB.Create(&submission).Error
+// GetAcceptedSubmissions returns each user's latest passed submission with
+// stored source for a problem
+func (s *GormStore) GetAcceptedSubmissions(problemID uint) ([]Submission, error) {
+	var submissions []Submission
+	err := s.db.Where("problem_id = ? AND status = ? AND source <> ''", problemID, StatusPassed).
+		Order("id desc").
+		Find(&submissions).Error
+	if err != nil {
+		return nil, err
+	}
+	return latestPerUser(submissions), nil
+}
+
+// latestPerUser keeps the first submission of each user from a list sorted
+// newest first
+func latestPerUser(submissions []Submission) []Submission {
+	seen := make(map[string]bool)
+	var latest []Submission
+	for _, submission := range submissions {
+		if !seen[submission.UserID] {
+			seen[submission.UserID] = true
+			latest = append(latest, submission)
+		}
+	}
+	return latest
+}
+
+type SubmissionFilter struct {
+	SubmissionID uint   `json:"submission_id"`
+	ProblemID    uint   `json:"problem_id"`
+	ContestID    uint   `json:"contest_id"`
+	UserID       string `json:"user_id"`
 }
 
 type LeaderboardEntry struct {
@@ -169,26 +817,27 @@ type LeaderboardEntry struct {
 	Score  int    `json:"score"`
 }
 
-func GetLeaderboard() ([]LeaderboardEntry, error) {
-	var entries []LeaderboardEntry
-	// Select user_id, sum points of unique passed problems
-	err := DB.Table("submissions").
-		Select("submissions.user_id, sum(problems.points) as score").
-		Joins("join problems on problems.id = submissions.problem_id").
-		Where("submissions.status = ?", "Passed").
-		Group("submissions.user_id").
-		Order("score desc").
-		Scan(&entries).Error
-	return entries, err
+// solvedProblems selects each (user_id, problem_id) pair with a passed
+// submission once, however many times the problem was solved
+func (s *GormStore) solvedProblems() *gorm.DB {
+	return s.db.Model(&Submission{}).Distinct("user_id", "problem_id").Where("status = ?", StatusPassed)
 }
 
-func GetContestLeaderboard(contestID uint) ([]LeaderboardEntry, error) {
+func (s *GormStore) GetLeaderboard(opts ListOptions) ([]LeaderboardEntry, Page, error) {
+	// Sum the points of each user's solved problems
+	scores := s.db.Table("(?) AS solved", s.solvedProblems()).
+		Select("solved.user_id, sum(problems.points) as score").
+		Joins("join problems on problems.id = solved.problem_id").
+		Group("solved.user_id")
+	return findPage(s.db.Table("(?) AS scores", scores), opts, leaderboardOrder)
+}
+
+func (s *GormStore) GetContestLeaderboard(contestID uint) ([]LeaderboardEntry, error) {
 	// 1. Get all registered users
 	var registrations []Registration
-	if err := DB.Where("contest_id = ?", contestID).Find(&registrations).Error; err != nil {
+	if err := s.db.Where("contest_id = ?", contestID).Find(&registrations).Error; err != nil {
 		return nil, err
 	}
-	println("DEBUG: Found registrations:", len(registrations), "for contest:", contestID)
 
 	// Map to track scores. Initialize with 0.
 	scores := make(map[string]int)
@@ -203,12 +852,11 @@ func GetContestLeaderboard(contestID uint) ([]LeaderboardEntry, error) {
 	}
 	var solved []UserProblemPoints
 
-	// Group by user and problem to ensure we don't double count points for multiple submissions
-	err := DB.Table("submissions").
-		Select("submissions.user_id, problems.points").
-		Joins("JOIN problems ON problems.id = submissions.problem_id").
-		Where("submissions.status = ? AND problems.contest_id = ?", "Passed", contestID).
-		Group("submissions.user_id, submissions.problem_id"). 
+	// Solved problems are distinct so multiple submissions aren't double counted
+	err := s.db.Table("(?) AS solved", s.solvedProblems()).
+		Select("solved.user_id, problems.points").
+		Joins("JOIN problems ON problems.id = solved.problem_id").
+		Where("problems.contest_id = ?", contestID).
 		Scan(&solved).Error
 
 	if err != nil {
@@ -246,12 +894,46 @@ type ProblemLeaderboardEntry struct {
 	Status    string    `json:"status"`
 }
 
-func GetProblemLeaderboard(problemID uint) ([]ProblemLeaderboardEntry, error) {
+func (s *GormStore) GetProblemLeaderboard(problemID uint) ([]ProblemLeaderboardEntry, error) {
 	var entries []ProblemLeaderboardEntry
-	err := DB.Table("submissions").
+	err := s.db.Table("submissions").
 		Select("user_id, created_at, status").
 		Where("problem_id = ? AND status = ?", problemID, "Passed").
 		Order("created_at asc").
 		Scan(&entries).Error
 	return entries, err
 }
+
+func (s *GormStore) RecordAuditEvent(event AuditEvent) error {
+	return s.db.Create(&event).Error
+}
+
+func (s *GormStore) FindAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	query := s.db.Model(&AuditEvent{})
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.EntityType != "" {
+		query = query.Where("entity_type = ?", filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var events []AuditEvent
+	err := query.Order("id desc").Find(&events).Error
+	return events, err
+}
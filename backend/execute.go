@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// MaxExecuteStdin is the most stdin a scratchpad run accepts
+const MaxExecuteStdin = 1 << 20
+
+// MaxExecuteBody bounds a whole POST /execute request: the source and the
+// stdin, which JSON escaping can make a few times longer
+const MaxExecuteBody = 8 << 20
+
+// executeSlots bounds the scratchpad runs in flight the way the judge
+// workers bound submissions
+var executeSlots = make(chan struct{}, runtime.NumCPU())
+
+// ExecuteResult is what a scratchpad run reports back to the user
+type ExecuteResult struct {
+	Stdout         string `json:"stdout"`
+	Stderr         string `json:"stderr"`
+	ExitCode       int    `json:"exit_code"`
+	Status         string `json:"status"` // Empty if the program ran to completion
+	TimeMS         int64  `json:"time_ms"`
+	MemoryKB       int64  `json:"memory_kb"` // 0 if the executor can't measure it
+	CompileOutput  string `json:"compile_output,omitempty"`
+	OutputExceeded bool   `json:"output_exceeded"`
+}
+
+// ExecuteScratch runs source with the given stdin, outside of any problem.
+// Nothing is judged or stored; the run directory is removed afterwards.
+func ExecuteScratch(ctx context.Context, lang Language, source string, stdin string) (ExecuteResult, error) {
+	var result ExecuteResult
+
+	select {
+	case executeSlots <- struct{}{}:
+		defer func() { <-executeSlots }()
+	case <-ctx.Done():
+		return result, ctx.Err()
+	}
+
+	if err := os.MkdirAll(WORKSPACE, 0755); err != nil {
+		return result, err
+	}
+	runPath, err := os.MkdirTemp(WORKSPACE, "execute-")
+	if err != nil {
+		return result, fmt.Errorf("workspace error: %v", err)
+	}
+	defer os.RemoveAll(runPath)
+	// MkdirTemp makes it private to us, but the program runs as the sandbox user
+	if err := os.Chmod(runPath, 0755); err != nil {
+		return result, fmt.Errorf("workspace error: %v", err)
+	}
+
+	if err := createFileFromText(runPath, lang.SourceFile, source); err != nil {
+		return result, fmt.Errorf("failed to write source: %v", err)
+	}
+	if err := createFileFromText(runPath, "input.txt", stdin); err != nil {
+		return result, fmt.Errorf("failed to write stdin: %v", err)
+	}
+
+	if err := compileInContainer(ctx, runPath, lang); err != nil {
+		compileErr, ok := err.(*CompileError)
+		if !ok {
+			return result, err
+		}
+		result.Status = StatusCompilationError
+		result.CompileOutput = compileErr.Output
+		return result, nil
+	}
+
+	outcome, err := Sandbox.Run(ctx, RunSpec{
+		WorkDir: runPath,
+		Command: lang.RunCommand + " < input.txt",
+		Image:   lang.Image,
+		RootFS:  lang.RootFS,
+		Limits:  lang.Limits(DefaultLimits),
+	})
+	if err != nil {
+		return result, err
+	}
+
+	result.Stdout = outcome.Stdout
+	result.Stderr = outcome.Stderr
+	result.ExitCode = outcome.ExitCode
+	result.TimeMS = outcome.Duration.Milliseconds()
+	result.MemoryKB = outcome.MemoryKB
+	result.OutputExceeded = outcome.OutputExceeded
+
+	switch {
+	case outcome.OutputExceeded:
+		result.Status = StatusOutputLimitExceeded
+	case outcome.TimedOut:
+		result.Status = StatusTimeLimitExceeded
+	case outcome.ExitCode != 0:
+		result.Status = StatusRuntimeError
+		if status := classifyViolation(outcome.Violations); status != "" {
+			result.Status = status
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestExecuteScratchWorkspaceIsReadable(t *testing.T) {
+	var mode os.FileMode
+	useExecutor(t, &fakeExecutor{run: func(spec RunSpec) RunOutcome {
+		info, err := os.Stat(spec.WorkDir)
+		if err == nil {
+			mode = info.Mode().Perm()
+		}
+		return RunOutcome{Stdout: "ok\n"}
+	}})
+	python := Language{ID: "python", SourceFile: "solution.py", RunCommand: "python solution.py"}
+
+	result, err := ExecuteScratch(context.Background(), python, "print('ok')", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Stdout != "ok\n" {
+		t.Errorf("stdout %q, want the program's output", result.Stdout)
+	}
+	if mode != 0755 {
+		t.Errorf("run directory mode %v, want 0755 so the sandbox user can read it", mode)
+	}
+}
+
+func TestExecuteRejectsOversizedBody(t *testing.T) {
+	executor := &fakeExecutor{run: echoRun}
+	useExecutor(t, executor)
+
+	body := `{"source":"print(1)","stdin":"` + strings.Repeat("x", MaxExecuteBody) + `"}`
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/execute", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handleExecute(c)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d, want 413", w.Code)
+	}
+	if len(executor.specs) != 0 {
+		t.Errorf("%d sandbox runs for a rejected request", len(executor.specs))
+	}
+}
+
+func TestExecuteSlotsBoundConcurrentRuns(t *testing.T) {
+	// Hold every slot; a run must wait for one rather than start
+	for i := 0; i < cap(executeSlots); i++ {
+		executeSlots <- struct{}{}
+	}
+	t.Cleanup(func() {
+		for i := 0; i < cap(executeSlots); i++ {
+			<-executeSlots
+		}
+	})
+	executor := &fakeExecutor{run: echoRun}
+	useExecutor(t, executor)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	python := Language{ID: "python", SourceFile: "solution.py", RunCommand: "python solution.py"}
+	if _, err := ExecuteScratch(ctx, python, "print(1)", ""); err != context.Canceled {
+		t.Errorf("error %v, want the run to give up waiting for a slot", err)
+	}
+	if len(executor.specs) != 0 {
+		t.Errorf("%d sandbox runs with no free slot", len(executor.specs))
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Limits bounds the resources one sandboxed run may use
+type Limits struct {
+	WallTime time.Duration
+	CPUTime  time.Duration // Native sandbox only; Docker relies on WallTime
+	MemoryMB int
+	CPUs     float64
+	FileSize int64 // Largest file the program may write, in bytes
+	Output   int64 // Bytes of stdout and of stderr kept; more is Output Limit Exceeded
+}
+
+// DefaultLimits are the limits every run had before they were configurable
+var DefaultLimits = Limits{
+	WallTime: 5 * time.Second,
+	CPUTime:  5 * time.Second,
+	MemoryMB: 128,
+	CPUs:     0.5,
+	FileSize: 64 << 20,
+	Output:   16 << 20,
+}
+
+// CompileLimits bound a language's compile step, which runs once per
+// submission rather than once per test
+var CompileLimits = Limits{
+	WallTime: 30 * time.Second,
+	CPUTime:  30 * time.Second,
+	MemoryMB: 512,
+	CPUs:     1,
+	FileSize: 64 << 20,
+	Output:   1 << 20,
+}
+
+// RunSpec describes one command to run in the sandbox
+type RunSpec struct {
+	WorkDir string // Host directory, mounted as /code and used as the working directory
+	Command string // Shell command, run with sh -c
+	Image   string // Docker image holding the language runtime
+	RootFS  string // Directory holding the language runtime for the native sandbox
+	Limits  Limits
+	// Writable mounts the workspace read-write whatever the policy says, so
+	// the compile step can leave its build there for the runs
+	Writable bool
+	// OnMarker, if set, is called with each marker the run writes to
+	// stderr, without the prefix. The run reads its marker prefix from
+	// stdin; see markerFilter.
+	OnMarker func(marker string)
+}
+
+// RunOutcome is what happened to a sandboxed command. A non-zero exit or a
+// timeout is a property of the program, not an error of the executor.
+type RunOutcome struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	TimedOut bool
+	// OutputExceeded means stdout or stderr passed Limits.Output; the
+	// program was killed and the captured streams are truncated
+	OutputExceeded bool
+	Duration       time.Duration
+	MemoryKB       int64 // Peak memory, 0 if the executor can't measure it
+	Violations     Violations
+}
+
+// Executor runs untrusted code in isolation. Cancelling ctx kills the run
+// and Run returns ctx's error. Any other error is a *SandboxError.
+type Executor interface {
+	Run(ctx context.Context, spec RunSpec) (RunOutcome, error)
+}
+
+var Sandbox Executor
+
+// SandboxError means the sandbox couldn't run the program at all, e.g. the
+// Docker daemon is down. It says nothing about the program, so it must never
+// become a verdict.
+type SandboxError struct {
+	Err error
+}
+
+func (e *SandboxError) Error() string {
+	return "sandbox error: " + e.Err.Error()
+}
+
+func (e *SandboxError) Unwrap() error {
+	return e.Err
+}
+
+// dockerFailedExit is the status docker run exits with when the daemon
+// couldn't create or start the container
+const dockerFailedExit = 125
+
+// InitExecutor loads the sandbox policy and selects the sandbox
+// implementation from JUDGE_EXECUTOR: "docker" (default) or "native" for
+// hosts where Docker isn't allowed
+func InitExecutor() {
+	policy, err := LoadSandboxPolicy()
+	if err != nil {
+		panic("error: " + err.Error())
+	}
+	Policy = policy
+
+	switch kind := os.Getenv("JUDGE_EXECUTOR"); kind {
+	case "", "docker":
+		Sandbox = &DockerExecutor{}
+	case "native":
+		executor, err := NewNativeExecutor()
+		if err != nil {
+			panic("error: " + err.Error())
+		}
+		Sandbox = executor
+	default:
+		panic("error: unknown JUDGE_EXECUTOR " + strconv.Quote(kind))
+	}
+}
+
+// DockerExecutor runs each command in a throwaway container. Docker doesn't
+// expose a container's pids events or seccomp notifications, so its runs
+// report no Violations and refused operations are plain runtime errors.
+type DockerExecutor struct {
+	runs atomic.Uint64 // Numbers the containers so they can be killed by name
+}
+
+func (d *DockerExecutor) Run(parent context.Context, spec RunSpec) (RunOutcome, error) {
+	var outcome RunOutcome
+
+	absWorkDir, err := filepath.Abs(spec.WorkDir)
+	if err != nil {
+		return outcome, &SandboxError{fmt.Errorf("failed to get absolute path: %v", err)}
+	}
+
+	// The timeout is a backstop in case the container never starts
+	ctx, cancel := context.WithTimeout(parent, spec.Limits.WallTime+30*time.Second)
+	defer cancel()
+
+	policy, err := runPolicy(absWorkDir, spec)
+	if err != nil {
+		return outcome, &SandboxError{err}
+	}
+
+	name := "codejudge-" + strconv.FormatUint(d.runs.Add(1), 10) + "-" + strconv.Itoa(os.Getpid())
+	cmd := exec.CommandContext(ctx, "docker", dockerRunArgs(absWorkDir, name, spec, policy)...)
+	// Killing the docker client leaves the container running, so kill the
+	// container itself
+	cmd.Cancel = func() error {
+		exec.Command("docker", "kill", name).Run()
+		return cmd.Process.Kill()
+	}
+
+	stdout := &limitedBuffer{Limit: spec.Limits.Output, OnExceed: cancel}
+	stderr := &limitedBuffer{Limit: spec.Limits.Output, OnExceed: cancel}
+	markers, err := newMarkerFilter(stderr, spec.OnMarker, spec.Limits.Output)
+	if err != nil {
+		return outcome, &SandboxError{err}
+	}
+	cmd.Stdin = markers.Stdin()
+	cmd.Stdout = stdout
+	cmd.Stderr = markers
+
+	start := time.Now()
+	err = cmd.Run()
+	markers.Flush()
+	outcome.Duration = time.Since(start)
+	outcome.Stdout = stdout.String()
+	outcome.Stderr = stderr.String()
+	outcome.OutputExceeded = stdout.Exceeded || stderr.Exceeded
+
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return outcome, &SandboxError{fmt.Errorf("failed to start container: %v", err)}
+		}
+		if parent.Err() != nil {
+			return outcome, parent.Err()
+		}
+		outcome.ExitCode = exitErr.ExitCode()
+		// A program exiting with 125 itself is taken for a daemon failure
+		// too; that only turns its Runtime Error into Error
+		if outcome.ExitCode == dockerFailedExit {
+			return outcome, &SandboxError{fmt.Errorf("docker failed: %s", strings.TrimSpace(outcome.Stderr))}
+		}
+		if outcome.ExitCode == 124 || (ctx.Err() != nil && !outcome.OutputExceeded) {
+			outcome.TimedOut = true
+		}
+	}
+	return outcome, nil
+}
+
+// runPolicy is the policy for one run. If the run may write to its
+// workspace, the workspace is handed to the unprivileged sandbox user.
+func runPolicy(workDir string, spec RunSpec) (SandboxPolicy, error) {
+	policy := Policy
+	if spec.Writable {
+		policy.ReadOnlyWorkspace = false
+	}
+	if policy.ReadOnlyWorkspace || policy.User == "" {
+		return policy, nil
+	}
+	uid, gid, err := policy.UserIDs()
+	if err != nil {
+		return policy, err
+	}
+	if err := chownTree(workDir, uid, gid); err != nil {
+		return policy, fmt.Errorf("failed to prepare workspace: %v", err)
+	}
+	return policy, nil
+}
+
+func chownTree(root string, uid, gid int) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+// dockerRunArgs translates the limits and sandbox policy into docker flags
+func dockerRunArgs(workDir string, name string, spec RunSpec, policy SandboxPolicy) []string {
+	limits := spec.Limits
+	args := []string{"run",
+		"--rm",
+		"--name=" + name,
+		"--cpus=" + strconv.FormatFloat(limits.CPUs, 'f', -1, 64),
+		"--memory=" + strconv.Itoa(limits.MemoryMB) + "m",
+		"--memory-swap=" + strconv.Itoa(limits.MemoryMB) + "m",
+		"--ulimit", "fsize=" + strconv.FormatInt(limits.FileSize, 10),
+	}
+
+	if !policy.Network {
+		args = append(args, "--network=none")
+	}
+	if policy.ProcessLimit > 0 {
+		args = append(args, "--pids-limit="+strconv.Itoa(policy.ProcessLimit))
+	}
+	if policy.ReadOnlyRoot {
+		args = append(args, "--read-only")
+	}
+	for _, capability := range policy.CapDrop {
+		args = append(args, "--cap-drop="+capability)
+	}
+	if policy.NoNewPrivileges {
+		args = append(args, "--security-opt=no-new-privileges")
+	}
+	if policy.User != "" {
+		args = append(args, "--user="+policy.User)
+	}
+	if spec.OnMarker != nil {
+		// The marker prefix is passed on stdin
+		args = append(args, "--interactive")
+	}
+	for _, name := range policy.UlimitNames() {
+		args = append(args, "--ulimit", name+"="+policy.Ulimits[name])
+	}
+
+	workspaceMount := workDir + ":/code"
+	if policy.ReadOnlyWorkspace {
+		workspaceMount += ":ro"
+	}
+	seconds := strconv.FormatFloat(limits.WallTime.Seconds(), 'f', -1, 64)
+	args = append(args,
+		// exec so programs can run what they build in /tmp
+		"--tmpfs", "/tmp:rw,exec,nosuid,nodev,size="+policy.ScratchSize,
+		"-e", "PYTHONDONTWRITEBYTECODE=1",
+		"-v", workspaceMount,
+		"-w", "/code",
+		spec.Image,
+		// timeout(1) inside the container enforces the wall time
+		"timeout", seconds+"s", "sh", "-c", spec.Command,
+	)
+	return args
+}
+
+// limitedBuffer keeps the first Limit bytes written to it and silently
+// drops the rest, calling OnExceed once when the limit is first crossed so
+// the run can be killed instead of filling the server's memory
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	Limit    int64
+	Exceeded bool
+	OnExceed func()
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.Limit - int64(b.buf.Len())
+	if int64(len(p)) <= remaining {
+		return b.buf.Write(p)
+	}
+	if remaining > 0 {
+		b.buf.Write(p[:remaining])
+	}
+	if !b.Exceeded {
+		b.Exceeded = true
+		if b.OnExceed != nil {
+			b.OnExceed()
+		}
+	}
+	// Report a full write so the copy from the pipe carries on draining
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+// judgeMarker starts the lines the function harness writes to stderr to
+// report progress
+const judgeMarker = "##judge-"
+
+// markerFilter passes stderr on to Out, minus the markers which go to
+// OnMarker instead. A marker is judgeMarker followed by a random nonce for
+// the run, which the program is given on stdin, so output that merely looks
+// like a marker isn't taken for one. It is no secret from code that shares
+// the harness's process, which can find the nonce and forge progress, so
+// markers must never decide a verdict. Markers are found anywhere in a
+// line, so leaving a line unfinished doesn't hide the next one either.
+type markerFilter struct {
+	Out      io.Writer
+	OnMarker func(marker string)
+	MaxLine  int64  // Longer lines can't be markers and are passed on as is
+	prefix   string // Empty without OnMarker: nothing is filtered
+	line     []byte
+}
+
+func newMarkerFilter(out io.Writer, onMarker func(string), maxLine int64) (*markerFilter, error) {
+	f := &markerFilter{Out: out, OnMarker: onMarker, MaxLine: maxLine}
+	if onMarker != nil {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, fmt.Errorf("failed to generate marker nonce: %v", err)
+		}
+		f.prefix = judgeMarker + hex.EncodeToString(nonce) + "-"
+	}
+	return f, nil
+}
+
+// Stdin is what the run reads its marker prefix from, nil without markers
+func (f *markerFilter) Stdin() io.Reader {
+	if f.prefix == "" {
+		return nil
+	}
+	return strings.NewReader(f.prefix + "\n")
+}
+
+func (f *markerFilter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			f.line = append(f.line, p...)
+			if int64(len(f.line)) > f.MaxLine {
+				f.Out.Write(f.line)
+				f.line = f.line[:0]
+			}
+			break
+		}
+		f.line = append(f.line, p[:i+1]...)
+		p = p[i+1:]
+		f.emit()
+	}
+	return n, nil
+}
+
+func (f *markerFilter) emit() {
+	line := string(f.line)
+	f.line = f.line[:0]
+	if f.prefix != "" {
+		if before, marker, ok := strings.Cut(line, f.prefix); ok {
+			f.Out.Write([]byte(before))
+			f.OnMarker(strings.TrimSpace(marker))
+			return
+		}
+	}
+	f.Out.Write([]byte(line))
+}
+
+// Flush handles a last line without a newline once the run has finished
+func (f *markerFilter) Flush() {
+	if len(f.line) > 0 {
+		f.emit()
+	}
+}
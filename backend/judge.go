@@ -1,15 +1,24 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 )
 
+// CompileError carries the compiler output of a submission that didn't build
+type CompileError struct {
+	Output string
+}
+
+func (e *CompileError) Error() string {
+	return "Compilation Error"
+}
+
 // RunResult represents the result of a single test case execution from the Python harness
 type RunResult struct {
 	Status    string      `json:"status"` // "ok", "runtime_error", "system_error"
@@ -17,19 +26,28 @@ type RunResult struct {
 	Error     string      `json:"error"`
 	Time      float64     `json:"time"`
 	Traceback string      `json:"traceback"`
+	// Violation is the policy verdict for an errored test in a run during
+	// which the sandbox refused something
+	Violation string `json:"-"`
 }
 
 // ExecuteFunctionRun orchestrates the Function-based execution pipeline
-func ExecuteFunctionRun(username string, solutionCode string, signature ProblemSignature, testCases []map[string]interface{}) ([]RunResult, error) {
+// onMarker, if set, receives the progress markers of the run: "compiled" and
+// "test <RunResult JSON>" after each test.
+func ExecuteFunctionRun(ctx context.Context, runID string, lang Language, solutionCode string, signature ProblemSignature, testCases []map[string]interface{}, limits Limits, onMarker func(string)) ([]RunResult, error) {
+	if !lang.SupportsFunctions() {
+		return nil, fmt.Errorf("%s can't be used for function-based problems", lang.Name)
+	}
+
 	// 1. Prepare Workspace
-	runPath, err := makeRunDirectory(username)
+	runPath, err := makeRunDirectory(runID)
 	if err != nil {
 		return nil, fmt.Errorf("workspace error: %v", err)
 	}
-	defer cleanRunDirectory(username) // Clean up after run
+	defer cleanRunDirectory(runID) // Clean up after run
 
-	// 2. Write User Code (solution.py)
-	if err := createFileFromText(runPath, "solution.py", solutionCode); err != nil {
+	// 2. Write User Code (e.g. solution.py)
+	if err := createFileFromText(runPath, lang.SourceFile, solutionCode); err != nil {
 		return nil, fmt.Errorf("failed to write solution: %v", err)
 	}
 
@@ -42,55 +60,303 @@ func ExecuteFunctionRun(username string, solutionCode string, signature ProblemS
 		return nil, fmt.Errorf("failed to write test cases: %v", err)
 	}
 
-	// 4. Prepare Harness (runner.py)
+	// 4. Prepare Harness (e.g. runner.py)
 	// We read the template and inject the target function name
-	harnessTemplate, err := os.ReadFile(filepath.Join("runner", "harness.py"))
+	harnessTemplate, err := os.ReadFile(lang.Harness)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read harness template: %v", err)
 	}
-	
-harnessCode := strings.Replace(string(harnessTemplate), "{METHOD_NAME}", signature.FunctionName, 1)
-	if err := createFileFromText(runPath, "runner.py", harnessCode); err != nil {
+
+	harnessCode := strings.Replace(string(harnessTemplate), "{METHOD_NAME}", signature.FunctionName, 1)
+	if err := createFileFromText(runPath, lang.HarnessFile, harnessCode); err != nil {
 		return nil, fmt.Errorf("failed to write runner: %v", err)
 	}
 
-	// 5. Execute in Docker
-	// We need the absolute path for the volume mount
-	absWorkDir, err := filepath.Abs(runPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get absolute path: %v", err)
+	// 5. Compile, if the language needs it
+	if err := compileInContainer(ctx, runPath, lang); err != nil {
+		return nil, err
+	}
+	if lang.CompileCommand != "" && onMarker != nil {
+		onMarker("compiled")
 	}
 
-	cmd := exec.Command("docker", "run",
-		"--rm",
-		"--cpus=0.5",
-		"--memory=128m",
-		"-v", absWorkDir+":/code",
-		"-w", "/code",
-		"python:3.11",
-		"sh", "-c", "timeout 5s python runner.py",
-	) 
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		if strings.Contains(err.Error(), "124") {
-			return nil, fmt.Errorf("Time Limit Exceeded")
+	// 6. Execute in the sandbox
+	outcome, err := Sandbox.Run(ctx, RunSpec{
+		WorkDir:  runPath,
+		Command:  lang.HarnessCommand,
+		Image:    lang.Image,
+		RootFS:   lang.RootFS,
+		Limits:   lang.Limits(limits),
+		OnMarker: onMarker,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if outcome.OutputExceeded {
+		return nil, fmt.Errorf("Output Limit Exceeded")
+	}
+	if outcome.TimedOut {
+		return nil, fmt.Errorf("Time Limit Exceeded")
+	}
+	if outcome.ExitCode != 0 {
+		if status := classifyViolation(outcome.Violations); status != "" {
+			return nil, &ViolationError{Status: status, Output: outcome.Stderr}
 		}
-		return nil, fmt.Errorf("execution error: %v, stderr: %s", err, stderr.String())
+		return nil, fmt.Errorf("execution error: exit status %d, stderr: %s", outcome.ExitCode, outcome.Stderr)
 	}
 
-	// 6. Parse Results
+	// 7. Parse Results
 	// The harness prints exactly one line of JSON at the end
-	outputLines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	outputLines := strings.Split(strings.TrimSpace(outcome.Stdout), "\n")
 	lastLine := outputLines[len(outputLines)-1]
 
 	var results []RunResult
 	if err := json.Unmarshal([]byte(lastLine), &results); err != nil {
 		return nil, fmt.Errorf("internal error: failed to parse runner output: %s", lastLine)
 	}
+	// The harness catches each test's errors, so the run itself succeeded
+	if status := classifyViolation(outcome.Violations); status != "" {
+		for i := range results {
+			if results[i].Status != "ok" {
+				results[i].Violation = status
+			}
+		}
+	}
 
 	return results, nil
 }
+
+// Verdict is the outcome of judging one solution against a problem's tests
+type Verdict struct {
+	Status         string `json:"status"`
+	Message        string `json:"message"`
+	Output         string `json:"output"`
+	ExpectedOutput string `json:"expected_output"`
+	ActualOutput   string `json:"actual_output"`
+	TestCaseInput  string `json:"test_case_input"`
+	PassedCount    int    `json:"passed_count"`
+	TotalCount     int    `json:"total_count"`
+	FailedIndex    int    `json:"failed_index"` // 1-based, -1 if every test passed
+}
+
+// JudgeSolution runs a solution against a problem's tests in the run
+// directory named runID. An error means the solution could not be judged to
+// completion; the returned Verdict still carries the status to record
+// (Runtime Error, Time Limit Exceeded or Error) and any captured output.
+func JudgeSolution(ctx context.Context, runID string, problem Problem, lang Language, source string, progress ProgressFunc) (Verdict, error) {
+	if progress == nil {
+		progress = func(JudgeEvent) {}
+	}
+	if problem.SignatureJSON != "" {
+		return judgeFunction(ctx, runID, problem, lang, source, progress)
+	}
+	return judgeIO(ctx, runID, problem, lang, source, progress)
+}
+
+// problemLimits returns the sandbox limits for running a problem's solutions
+func problemLimits(problem Problem) Limits {
+	limits := DefaultLimits
+	if problem.OutputLimitKB > 0 {
+		limits.Output = int64(problem.OutputLimitKB) << 10
+	}
+	return limits
+}
+
+// judgeFunction runs the LeetCode-style pipeline: the harness calls the
+// user's method once per test case and the return values are compared as JSON
+func judgeFunction(ctx context.Context, runID string, problem Problem, lang Language, source string, progress ProgressFunc) (Verdict, error) {
+	verdict := Verdict{Status: StatusError, FailedIndex: -1}
+
+	var signature ProblemSignature
+	if err := json.Unmarshal([]byte(problem.SignatureJSON), &signature); err != nil {
+		return verdict, fmt.Errorf("Invalid problem signature")
+	}
+
+	var testCases []map[string]interface{}
+	if err := json.Unmarshal([]byte(problem.TestCasesJSON), &testCases); err != nil {
+		return verdict, fmt.Errorf("Invalid test cases")
+	}
+	verdict.TotalCount = len(testCases)
+
+	// The harness reports each result as it goes, so partial verdicts can be
+	// streamed before the final list is parsed
+	progress(startEvent(lang, len(testCases)))
+	finished, passedSoFar := 0, 0
+	onMarker := func(marker string) {
+		if marker == "compiled" {
+			progress(JudgeEvent{Event: EventRunning, Total: len(testCases)})
+			return
+		}
+		data, ok := strings.CutPrefix(marker, "test ")
+		if !ok || finished >= len(testCases) {
+			return
+		}
+		var res RunResult
+		if json.Unmarshal([]byte(data), &res) != nil {
+			return
+		}
+		status := StatusFailed
+		if resultMatches(res, testCases[finished]["output"]) {
+			status = StatusPassed
+			passedSoFar++
+		}
+		finished++
+		progress(JudgeEvent{Event: EventTest, Test: finished, Total: len(testCases), Passed: passedSoFar, Status: status})
+	}
+
+	results, err := ExecuteFunctionRun(ctx, runID, lang, source, signature, testCases, problemLimits(problem), onMarker)
+	if err != nil {
+		if compileErr, ok := err.(*CompileError); ok {
+			verdict.Status = StatusCompilationError
+			verdict.Output = compileErr.Output
+		} else if err.Error() == "Time Limit Exceeded" {
+			verdict.Status = StatusTimeLimitExceeded
+		} else if err.Error() == "Output Limit Exceeded" {
+			verdict.Status = StatusOutputLimitExceeded
+		} else if violation, ok := err.(*ViolationError); ok {
+			verdict.Status = violation.Status
+		}
+		return verdict, err
+	}
+
+	// Validation & Scoring
+	verdict.Status = StatusPassed
+	var firstFailedResult *RunResult
+	var firstFailedInput interface{}
+	var firstFailedExpected interface{}
+
+	for i, res := range results {
+		if i >= len(testCases) {
+			break
+		}
+		expected := testCases[i]["output"]
+
+		if resultMatches(res, expected) {
+			verdict.PassedCount++
+		} else if firstFailedResult == nil {
+			verdict.Status = StatusFailed
+			firstFailedResult = &results[i]
+			firstFailedInput = testCases[i]["input"]
+			firstFailedExpected = expected
+			verdict.FailedIndex = i + 1 // 1-based index
+		}
+	}
+	if len(results) < len(testCases) && firstFailedResult == nil {
+		// The harness bailed out early (e.g. the method is missing)
+		verdict.Status = StatusFailed
+		verdict.FailedIndex = len(results) + 1
+		if len(results) > 0 {
+			verdict.Output = results[len(results)-1].Error
+		}
+		verdict.ActualOutput = verdict.Output
+	}
+
+	// Format response
+	if firstFailedResult != nil {
+		outputBytes, _ := json.Marshal(firstFailedResult.Result)
+		if firstFailedResult.Status != "ok" {
+			verdict.Output = firstFailedResult.Error // Show error if runtime error
+			if firstFailedResult.Violation != "" {
+				verdict.Status = firstFailedResult.Violation
+			}
+		} else {
+			verdict.Output = string(outputBytes)
+		}
+		verdict.ActualOutput = verdict.Output
+
+		expBytes, _ := json.Marshal(firstFailedExpected)
+		verdict.ExpectedOutput = string(expBytes)
+
+		inBytes, _ := json.Marshal(firstFailedInput)
+		verdict.TestCaseInput = string(inBytes)
+	}
+
+	return verdict, nil
+}
+
+// resultMatches reports whether a harness result is the expected return value
+func resultMatches(res RunResult, expected interface{}) bool {
+	resBytes, _ := json.Marshal(res.Result)
+	expBytes, _ := json.Marshal(expected)
+	return res.Status == "ok" && string(resBytes) == string(expBytes)
+}
+
+// judgeIO runs the legacy pipeline: the solution reads each test's input on
+// stdin and its stdout is compared line by line with the expected output.
+// Tests run in order and judging stops at the first failure.
+func judgeIO(ctx context.Context, runID string, problem Problem, lang Language, source string, progress ProgressFunc) (Verdict, error) {
+	verdict := Verdict{Status: StatusError, FailedIndex: -1}
+
+	tests, err := ioTests(problem)
+	if err != nil {
+		return verdict, err
+	}
+	verdict.TotalCount = len(tests)
+	defer cleanRunDirectory(runID)
+
+	progress(startEvent(lang, len(tests)))
+	if err := hydrateRunDirectory(runID, problem, lang, source); err != nil {
+		return verdict, err
+	}
+	// Every test runs the same build
+	if err := compileInContainer(ctx, filepath.Join(WORKSPACE, runID), lang); err != nil {
+		if compileErr, ok := err.(*CompileError); ok {
+			verdict.Status = StatusCompilationError
+			verdict.Output = compileErr.Output
+		}
+		return verdict, err
+	}
+	if lang.CompileCommand != "" {
+		progress(JudgeEvent{Event: EventRunning, Total: len(tests)})
+	}
+
+	for i, test := range tests {
+		if err := hydrateTestCase(runID, test); err != nil {
+			return verdict, err
+		}
+
+		message, err := runInContainer(ctx, runID, lang, problemLimits(problem))
+		if err != nil {
+			// Keep the stderr (message) so the user can see the Python traceback
+			verdict.Status = StatusRuntimeError
+			var sandboxErr *SandboxError
+			if errors.As(err, &sandboxErr) {
+				// Not the program's fault; a rejudge must keep the old verdict
+				verdict.Status = StatusError
+			} else if err.Error() == "Time Limit Exceeded" {
+				verdict.Status = StatusTimeLimitExceeded
+			} else if err.Error() == "Output Limit Exceeded" {
+				verdict.Status = StatusOutputLimitExceeded
+			} else if violation, ok := err.(*ViolationError); ok {
+				verdict.Status = violation.Status
+			}
+			verdict.Output = message
+			verdict.FailedIndex = i + 1
+			progress(JudgeEvent{Event: EventTest, Test: i + 1, Total: len(tests), Passed: verdict.PassedCount, Status: verdict.Status})
+			return verdict, err
+		}
+		verdict.Message = message
+
+		output, err := getOutputText(runID)
+		if err != nil {
+			return verdict, err
+		}
+		verdict.Output = output
+
+		passed, expected, actual, input, err := checkOutput(runID)
+		verdict.ExpectedOutput = expected
+		verdict.ActualOutput = actual
+		verdict.TestCaseInput = input
+		if err != nil || !passed {
+			verdict.Status = StatusFailed
+			verdict.FailedIndex = i + 1
+			progress(JudgeEvent{Event: EventTest, Test: i + 1, Total: len(tests), Passed: verdict.PassedCount, Status: verdict.Status})
+			return verdict, nil
+		}
+		verdict.PassedCount++
+		progress(JudgeEvent{Event: EventTest, Test: i + 1, Total: len(tests), Passed: verdict.PassedCount, Status: StatusPassed})
+	}
+
+	verdict.Status = StatusPassed
+	return verdict, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeExecutor records the runs it is given and answers them with run, or
+// fails them with err
+type fakeExecutor struct {
+	mu    sync.Mutex
+	specs []RunSpec
+	run   func(spec RunSpec) RunOutcome
+	err   error
+}
+
+func (f *fakeExecutor) Run(ctx context.Context, spec RunSpec) (RunOutcome, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.specs = append(f.specs, spec)
+	if f.err != nil {
+		return RunOutcome{}, f.err
+	}
+	return f.run(spec), nil
+}
+
+// useExecutor swaps the sandbox for the duration of a test
+func useExecutor(t *testing.T, executor Executor) {
+	previous := Sandbox
+	Sandbox = executor
+	t.Cleanup(func() { Sandbox = previous })
+}
+
+var testCompiledLanguage = Language{
+	ID:             "cpp",
+	Name:           "C++",
+	Image:          "gcc:13",
+	SourceFile:     "solution.cpp",
+	CompileCommand: "g++ -o solution solution.cpp",
+	RunCommand:     "./solution",
+}
+
+var testIOProblem = Problem{
+	TestCasesJSON: `[{"input":"1","output":"1"},{"input":"2","output":"2"},{"input":"3","output":"3"}]`,
+}
+
+// echoRun answers runs with their input, and compiles with success
+func echoRun(spec RunSpec) RunOutcome {
+	if spec.Writable {
+		return RunOutcome{}
+	}
+	input, _ := os.ReadFile(filepath.Join(spec.WorkDir, "input.txt"))
+	return RunOutcome{Stdout: string(input)}
+}
+
+func TestJudgeCompilesOncePerSubmission(t *testing.T) {
+	executor := &fakeExecutor{run: echoRun}
+	useExecutor(t, executor)
+
+	verdict, err := JudgeSolution(context.Background(), "test-compile-once", testIOProblem, testCompiledLanguage, "int main() {}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verdict.Status != StatusPassed || verdict.PassedCount != 3 {
+		t.Fatalf("verdict %+v, want all 3 tests passed", verdict)
+	}
+
+	if len(executor.specs) != 4 {
+		t.Fatalf("%d sandbox runs, want 1 compile and 3 tests", len(executor.specs))
+	}
+	compile := executor.specs[0]
+	if compile.Command != testCompiledLanguage.CompileCommand || !compile.Writable || compile.Limits != CompileLimits {
+		t.Errorf("first run %+v is not the compile step with compile limits", compile)
+	}
+	for _, spec := range executor.specs[1:] {
+		if spec.Writable || spec.Command != "./solution < input.txt" {
+			t.Errorf("test run %+v should run the build read-only", spec)
+		}
+	}
+}
+
+func TestJudgeCompilationError(t *testing.T) {
+	executor := &fakeExecutor{run: func(spec RunSpec) RunOutcome {
+		return RunOutcome{ExitCode: 1, Stderr: "solution.cpp:1: error"}
+	}}
+	useExecutor(t, executor)
+
+	verdict, err := JudgeSolution(context.Background(), "test-compile-error", testIOProblem, testCompiledLanguage, "int main() {", nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if verdict.Status != StatusCompilationError || verdict.Output != "solution.cpp:1: error" {
+		t.Errorf("verdict %+v, want Compilation Error with the compiler output", verdict)
+	}
+	if len(executor.specs) != 1 {
+		t.Errorf("%d sandbox runs, want only the compile step", len(executor.specs))
+	}
+}
+
+// A program choosing the exit status the compile wrapper used to report
+// must not pass for a compile failure
+func TestJudgeExitStatusIsNotCompilationError(t *testing.T) {
+	executor := &fakeExecutor{run: func(spec RunSpec) RunOutcome {
+		if spec.Writable {
+			return RunOutcome{}
+		}
+		return RunOutcome{ExitCode: 86, Stderr: "bye"}
+	}}
+	useExecutor(t, executor)
+
+	verdict, _ := JudgeSolution(context.Background(), "test-exit-86", testIOProblem, testCompiledLanguage, "int main() { return 86; }", nil)
+	if verdict.Status != StatusRuntimeError || verdict.FailedIndex != 1 {
+		t.Errorf("verdict %+v, want Runtime Error on test 1", verdict)
+	}
+}
+
+func TestJudgeOutputLimitExceeded(t *testing.T) {
+	executor := &fakeExecutor{run: func(spec RunSpec) RunOutcome {
+		if spec.Writable {
+			return RunOutcome{}
+		}
+		return RunOutcome{Stdout: "yyyy", OutputExceeded: true}
+	}}
+	useExecutor(t, executor)
+	problem := testIOProblem
+	problem.OutputLimitKB = 4
+
+	verdict, _ := JudgeSolution(context.Background(), "test-output-limit", problem, testCompiledLanguage, "int main() { for (;;) puts(\"y\"); }", nil)
+	if verdict.Status != StatusOutputLimitExceeded || verdict.FailedIndex != 1 {
+		t.Errorf("verdict %+v, want Output Limit Exceeded on test 1", verdict)
+	}
+	if len(executor.specs) != 2 {
+		t.Fatalf("%d sandbox runs, want the compile and the first test", len(executor.specs))
+	}
+	if limit := executor.specs[1].Limits.Output; limit != 4<<10 {
+		t.Errorf("test ran with an output limit of %d bytes, want the problem's 4 KB", limit)
+	}
+}
+
+func TestJudgeSandboxFailureIsNotAVerdict(t *testing.T) {
+	useExecutor(t, &fakeExecutor{err: &SandboxError{errors.New("Cannot connect to the Docker daemon")}})
+	python := Language{ID: "python", SourceFile: "solution.py", RunCommand: "python solution.py"}
+
+	verdict, err := JudgeSolution(context.Background(), "test-sandbox-down", testIOProblem, python, "print(1)", nil)
+	if err == nil || verdict.Status != StatusError {
+		t.Fatalf("verdict %+v, error %v; want Error", verdict, err)
+	}
+}
+
+// A rejudge while the sandbox is down must leave the recorded verdict alone
+func TestRejudgeKeepsVerdictWhenSandboxFails(t *testing.T) {
+	useExecutor(t, &fakeExecutor{err: &SandboxError{errors.New("Cannot connect to the Docker daemon")}})
+	Languages = []Language{{ID: "python", SourceFile: "solution.py", RunCommand: "python solution.py"}}
+	InitBroker()
+	stores := NewMemoryStores()
+	InitQueue(1, stores.Submissions)
+	t.Cleanup(Queue.Shutdown)
+
+	submission := Submission{UserID: "alice", Language: "python", Source: "print(1)", Status: StatusPassed, PassedCount: 3, TotalCount: 3}
+	if err := stores.Submissions.CreateSubmission(&submission); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case outcome := <-Queue.Submit(context.Background(), submission, testIOProblem, true):
+		if outcome.Verdict.Status != StatusError {
+			t.Errorf("rejudge verdict %q, want Error", outcome.Verdict.Status)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("rejudge never finished")
+	}
+	stored, err := stores.Submissions.GetSubmissionByID(submission.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != StatusPassed || stored.PassedCount != 3 {
+		t.Errorf("stored submission %+v, want the earlier Passed verdict kept", stored)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Language describes how to build and run programs in one language. The
+// registry is loaded from LANGUAGES_FILE (languages.json by default) so
+// adding a language or upgrading a runtime is a config change.
+type Language struct {
+	ID             string  `json:"id"`              // e.g. "python"
+	Name           string  `json:"name"`            // e.g. "Python 3.11"
+	Image          string  `json:"image"`           // Docker image with the toolchain
+	RootFS         string  `json:"rootfs"`          // Runtime root for the native sandbox, overridden by SANDBOX_<ID>_ROOTFS
+	SourceFile     string  `json:"source_file"`     // File the submission is saved as
+	CompileCommand string  `json:"compile_command"` // Empty for interpreted languages; builds into the working directory
+	RunCommand     string  `json:"run_command"`
+	TimeMultiplier float64 `json:"time_multiplier"` // Scales the time limits for slower runtimes
+
+	// Function-based problems need a harness that loads the user's class and
+	// calls the method once per test case. Languages without one can only
+	// be used for IO-based problems.
+	Harness        string `json:"harness"`         // Path to the harness template
+	HarnessFile    string `json:"harness_file"`    // File the harness is saved as
+	HarnessCommand string `json:"harness_command"` // Runs the harness
+}
+
+// SupportsFunctions reports whether the language can run function-based problems
+func (l Language) SupportsFunctions() bool {
+	return l.Harness != ""
+}
+
+// Limits scales the time limits by the language's multiplier
+func (l Language) Limits(limits Limits) Limits {
+	if l.TimeMultiplier > 0 {
+		limits.WallTime = time.Duration(float64(limits.WallTime) * l.TimeMultiplier)
+		limits.CPUTime = time.Duration(float64(limits.CPUTime) * l.TimeMultiplier)
+	}
+	return limits
+}
+
+var Languages []Language
+
+// InitLanguages loads the language registry
+func InitLanguages() {
+	path := os.Getenv("LANGUAGES_FILE")
+	if path == "" {
+		path = "languages.json"
+	}
+	languages, err := LoadLanguages(path)
+	if err != nil {
+		panic("error: " + err.Error())
+	}
+	Languages = languages
+}
+
+func LoadLanguages(path string) ([]Language, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var languages []Language
+	if err := json.Unmarshal(data, &languages); err != nil {
+		return nil, fmt.Errorf("invalid language registry %s: %v", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for i, lang := range languages {
+		if lang.ID == "" || lang.SourceFile == "" || lang.RunCommand == "" || lang.Image == "" {
+			return nil, fmt.Errorf("language %q: id, image, source_file and run_command are required", lang.ID)
+		}
+		if seen[lang.ID] {
+			return nil, fmt.Errorf("language %q is defined twice", lang.ID)
+		}
+		seen[lang.ID] = true
+		if lang.Harness != "" && (lang.HarnessFile == "" || lang.HarnessCommand == "") {
+			return nil, fmt.Errorf("language %q: harness needs harness_file and harness_command", lang.ID)
+		}
+		// Where the runtimes live differs between hosts more than the rest,
+		// e.g. SANDBOX_PYTHON_ROOTFS
+		if dir := os.Getenv("SANDBOX_" + strings.ToUpper(lang.ID) + "_ROOTFS"); dir != "" {
+			languages[i].RootFS = dir
+		}
+	}
+	return languages, nil
+}
+
+// PublicLanguage is a language as GET /languages shows it, without the
+// host paths of its runtime and harness
+type PublicLanguage struct {
+	ID                string  `json:"id"`
+	Name              string  `json:"name"`
+	SourceFile        string  `json:"source_file"`
+	CompileCommand    string  `json:"compile_command,omitempty"`
+	RunCommand        string  `json:"run_command"`
+	TimeMultiplier    float64 `json:"time_multiplier"`
+	SupportsFunctions bool    `json:"supports_functions"`
+}
+
+// Public returns what clients may see of the language
+func (l Language) Public() PublicLanguage {
+	return PublicLanguage{
+		ID:                l.ID,
+		Name:              l.Name,
+		SourceFile:        l.SourceFile,
+		CompileCommand:    l.CompileCommand,
+		RunCommand:        l.RunCommand,
+		TimeMultiplier:    l.TimeMultiplier,
+		SupportsFunctions: l.SupportsFunctions(),
+	}
+}
+
+// GetLanguage looks a language up by ID
+func GetLanguage(id string) (Language, error) {
+	for _, lang := range Languages {
+		if lang.ID == id {
+			return lang, nil
+		}
+	}
+	return Language{}, fmt.Errorf("unsupported language %q", id)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadLanguagesRootFSOverride(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "languages.json")
+	registry := `[{"id": "python", "image": "python:3.11", "rootfs": "/opt/codejudge/rootfs/python", "source_file": "solution.py", "run_command": "python solution.py"}]`
+	if err := os.WriteFile(path, []byte(registry), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SANDBOX_PYTHON_ROOTFS", "/srv/python")
+	languages, err := LoadLanguages(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if languages[0].RootFS != "/srv/python" {
+		t.Errorf("rootfs %q, want the SANDBOX_PYTHON_ROOTFS override", languages[0].RootFS)
+	}
+}
+
+func TestPublicLanguageHidesHostPaths(t *testing.T) {
+	lang := Language{
+		ID:             "python",
+		Name:           "Python 3.11",
+		RootFS:         "/opt/codejudge/rootfs/python",
+		SourceFile:     "solution.py",
+		RunCommand:     "python solution.py",
+		Harness:        "runner/harness.py",
+		HarnessFile:    "runner.py",
+		HarnessCommand: "python runner.py",
+	}
+	data, err := json.Marshal(lang.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []string{lang.RootFS, lang.Harness} {
+		if strings.Contains(string(data), private) {
+			t.Errorf("%s exposes %q", data, private)
+		}
+	}
+	if !lang.Public().SupportsFunctions {
+		t.Error("a language with a harness should report function support")
+	}
+}
@@ -1,52 +1,55 @@
 package main
 
 import (
-	// "net/http"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
 	"time"
-
-	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/gin"
 )
 
 func main() {
-	InitDatabase()
+	if len(os.Args) > 1 && os.Args[1] == sandboxInitArg {
+		sandboxInit()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	InitBlobStore()
+	stores, err := OpenStores()
+	if err != nil {
+		panic("error: " + err.Error())
+	}
+	app := NewServer(stores)
+	app.EnsureAdmin()
+	InitLanguages()
+	InitExecutor()
 	InitBroker()
-	router := gin.Default()
-
-	router.Use(cors.New(cors.Config{
-		AllowAllOrigins:  true,
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
-
-	router.GET("/", sayHello)
-	router.POST("/run", handleRun)
-	router.POST("/user/create", handleCreateUser)
-	router.POST("/user/exists", handleUserExists)
-
-	router.POST("/contest", handleCreateContest)
-	router.PUT("/contest", handleUpdateContest)
-	router.DELETE("/contest/:id", handleDeleteContest)
-	router.GET("/contests", handleGetContests)
-	router.GET("/contest/:id", handleGetContest)
-	router.POST("/problem", handleCreateProblem)
-	router.GET("/problem/:id", handleGetProblem)
-	router.GET("/problems/practice", handleGetPracticeProblems)
-	router.GET("/problems", handleGetAllProblems)
-	router.GET("/users", handleGetAllUsers)
-	router.PUT("/problem", handleUpdateProblem)
-	router.DELETE("/problem/:id", handleDeleteProblem)
-
-	router.POST("/contest/register", handleRegisterContest)
-	router.GET("/contest/status", handleGetRegistrationStatus)
-	router.GET("/contest/:id/registrations", handleGetContestRegistrations)
-	router.GET("/leaderboard", handleGetLeaderboard)
-	router.GET("/contest/:id/leaderboard", handleGetContestLeaderboard)
-	router.GET("/contest/:id/leaderboard/stream", handleLeaderboardStream)
-	router.GET("/problem/:id/leaderboard", handleGetProblemLeaderboard)
-
-	router.Run(":8080")
+	InitQueue(runtime.NumCPU(), stores.Submissions)
+
+	server := &http.Server{Addr: ":8080", Handler: app.Router()}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			panic("error: " + err.Error())
+		}
+	}()
+
+	// On shutdown, cancel outstanding runs first so the handlers waiting on
+	// them can return, then let the server drain
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	Queue.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
 }
@@ -0,0 +1,797 @@
+package main
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MemoryStore implements every store in process memory. It is meant for
+// tests and throwaway instances (DB_DRIVER=memory) and behaves like
+// GormStore, down to returning gorm.ErrRecordNotFound for missing rows.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	users         map[string]User
+	sessions      map[string]Session
+	contests      map[uint]Contest
+	staff         []ContestStaff
+	problems      map[uint]Problem // Without test data, see tests
+	tests         map[uint][]IOTest
+	revisions     map[uint][]memoryRevision // By problem, oldest first
+	registrations []Registration
+	submissions   map[uint]Submission
+	verdicts      map[string]CachedVerdict
+	audit         []AuditEvent
+
+	lastIDs map[string]uint // Per table, like autoincrement columns
+}
+
+// NewMemoryStores returns empty in-memory stores
+func NewMemoryStores() Stores {
+	store := &MemoryStore{
+		users:       make(map[string]User),
+		sessions:    make(map[string]Session),
+		contests:    make(map[uint]Contest),
+		problems:    make(map[uint]Problem),
+		tests:       make(map[uint][]IOTest),
+		revisions:   make(map[uint][]memoryRevision),
+		submissions: make(map[uint]Submission),
+		verdicts:    make(map[string]CachedVerdict),
+		lastIDs:     make(map[string]uint),
+	}
+	return Stores{
+		Users:         store,
+		Contests:      store,
+		Problems:      store,
+		Registrations: store,
+		Submissions:   store,
+		Audit:         store,
+	}
+}
+
+// memoryPage sorts items in order and returns the page of them opts
+// selects, like findPage
+func memoryPage[T any](items []T, opts ListOptions, order listOrder[T]) ([]T, Page, error) {
+	key, err := order.key(opts.Sort)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	// compare orders a row against the sort value and tiebreak of another
+	compare := func(item T, value, tiebreak any) int {
+		c := compareKeys(key.Value(item), value)
+		if opts.Desc {
+			c = -c
+		}
+		if c == 0 {
+			c = compareKeys(order.Tiebreak.Value(item), tiebreak)
+		}
+		return c
+	}
+	slices.SortFunc(items, func(a, b T) int {
+		return compare(a, key.Value(b), order.Tiebreak.Value(b))
+	})
+
+	page := Page{Total: int64(len(items))}
+	if opts.After != nil {
+		start := slices.IndexFunc(items, func(item T) bool { return compare(item, opts.After[0], opts.After[1]) > 0 })
+		if start < 0 {
+			start = len(items)
+		}
+		items = items[start:]
+	}
+	items, page.Next = nextPage(items, opts, key, order.Tiebreak)
+	return items, page, nil
+}
+
+// compareKeys compares two sort keys of the same type
+func compareKeys(a, b any) int {
+	switch a := a.(type) {
+	case string:
+		return cmp.Compare(a, b.(string))
+	case int:
+		return cmp.Compare(a, b.(int))
+	case uint:
+		return cmp.Compare(a, b.(uint))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	panic(fmt.Sprintf("cannot compare %T", a))
+}
+
+// nextID returns a fresh ID for table, or id itself if it is already set
+func (s *MemoryStore) nextID(table string, id uint) uint {
+	if id == 0 {
+		s.lastIDs[table]++
+		return s.lastIDs[table]
+	}
+	s.lastIDs[table] = max(s.lastIDs[table], id)
+	return id
+}
+
+func (s *MemoryStore) CreateUser(user User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user.Username]; ok {
+		return ErrUserExists
+	}
+	if user.Role == "" {
+		user.Role = RoleContestant
+	}
+	s.users[user.Username] = user
+	return nil
+}
+
+func (s *MemoryStore) ListUsers(filter UserFilter, opts ListOptions) ([]User, Page, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	query := strings.ToLower(filter.Query)
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		if filter.Role != "" && user.Role != filter.Role {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(user.Username), query) && !strings.Contains(strings.ToLower(user.Email), query) {
+			continue
+		}
+		users = append(users, user)
+	}
+	return memoryPage(users, opts, userOrder)
+}
+
+func (s *MemoryStore) GetUser(username string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[username]
+	if !ok {
+		return User{}, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+func (s *MemoryStore) UserExists(username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.users[username]
+	return ok, nil
+}
+
+func (s *MemoryStore) VerifyUser(username, password string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[username]
+	if !ok {
+		return false, nil
+	}
+	if isPasswordHash(user.Password) {
+		return CheckPassword(user.Password, password), nil
+	}
+	if subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+		return false, nil
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return false, err
+	}
+	user.Password = hash
+	s.users[username] = user
+	return true, nil
+}
+
+func (s *MemoryStore) SetUserRole(username, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[username]; ok {
+		user.Role = role
+		s.users[username] = user
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateSession(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session.TokenHash] = session
+	return nil
+}
+
+func (s *MemoryStore) GetSessionUser(tokenHash string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[tokenHash]
+	if !ok || !session.ExpiresAt.After(time.Now()) {
+		return User{}, gorm.ErrRecordNotFound
+	}
+	user, ok := s.users[session.Username]
+	if !ok {
+		return User{}, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+func (s *MemoryStore) DeleteSession(tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, tokenHash)
+	return nil
+}
+
+func (s *MemoryStore) DeleteUserSessions(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, session := range s.sessions {
+		if session.Username == username {
+			delete(s.sessions, hash)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpiredSessions() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for hash, session := range s.sessions {
+		if !session.ExpiresAt.After(now) {
+			delete(s.sessions, hash)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateContest(contest *Contest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contest.ID = s.nextID("contests", contest.ID)
+	stored := *contest
+	stored.Problems = nil
+	s.contests[contest.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) UpdateContest(contest Contest) error {
+	return s.CreateContest(&contest)
+}
+
+func (s *MemoryStore) DeleteContest(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Its problems become practice problems
+	for problemID, problem := range s.problems {
+		if problem.ContestID == id {
+			problem.ContestID = 0
+			s.problems[problemID] = problem
+		}
+	}
+	staff := s.staff[:0]
+	for _, member := range s.staff {
+		if member.ContestID != id {
+			staff = append(staff, member)
+		}
+	}
+	s.staff = staff
+	delete(s.contests, id)
+	return nil
+}
+
+func (s *MemoryStore) ListContests(filter ContestFilter, opts ListOptions) ([]Contest, Page, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	contests := make([]Contest, 0, len(s.contests))
+	for _, contest := range s.contests {
+		switch {
+		case filter.Status == "upcoming" && !contest.StartTime.After(now),
+			filter.Status == "active" && (contest.StartTime.After(now) || contest.EndTime.Before(now)),
+			filter.Status == "ended" && !contest.EndTime.Before(now):
+			continue
+		}
+		contests = append(contests, contest)
+	}
+	return memoryPage(contests, opts, contestOrder)
+}
+
+func (s *MemoryStore) GetContestByID(id uint) (Contest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contest, ok := s.contests[id]
+	if !ok {
+		return Contest{}, gorm.ErrRecordNotFound
+	}
+	contest.Problems = withoutHiddenCode(s.problemsWhere(func(problem Problem) bool { return problem.ContestID == id }))
+	return contest, nil
+}
+
+func (s *MemoryStore) AddContestStaff(contestID uint, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, member := range s.staff {
+		if member.ContestID == contestID && member.Username == username {
+			return nil
+		}
+	}
+	s.staff = append(s.staff, ContestStaff{ID: s.nextID("contest_staff", 0), ContestID: contestID, Username: username})
+	return nil
+}
+
+func (s *MemoryStore) RemoveContestStaff(contestID uint, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	staff := s.staff[:0]
+	for _, member := range s.staff {
+		if member.ContestID != contestID || member.Username != username {
+			staff = append(staff, member)
+		}
+	}
+	s.staff = staff
+	return nil
+}
+
+func (s *MemoryStore) GetContestStaff(contestID uint) ([]ContestStaff, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var staff []ContestStaff
+	for _, member := range s.staff {
+		if member.ContestID == contestID {
+			staff = append(staff, member)
+		}
+	}
+	return staff, nil
+}
+
+func (s *MemoryStore) IsContestStaff(contestID uint, username string) (bool, error) {
+	staff, _ := s.GetContestStaff(contestID)
+	for _, member := range staff {
+		if member.Username == username {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// memoryRevision is a ProblemRevision with the problem and tests it holds
+type memoryRevision struct {
+	ProblemRevision
+	problem Problem // Without test data
+	tests   []IOTest
+}
+
+func (s *MemoryStore) CreateProblem(problem *Problem, author string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	problem.ID = s.nextID("problems", problem.ID)
+	problem.Revision = len(s.revisions[problem.ID]) + 1
+	return s.saveProblem(*problem, true, author)
+}
+
+// saveProblem stores a problem with its Revision set, and its tests if
+// replaceTests is set, and records the revision
+func (s *MemoryStore) saveProblem(problem Problem, replaceTests bool, author string) error {
+	if replaceTests {
+		tests, err := problemTestFiles(problem)
+		if err != nil {
+			return err
+		}
+		s.tests[problem.ID] = tests
+		s.deleteCachedVerdicts(problem.ID)
+	}
+	problem.Input, problem.Output, problem.TestCasesJSON = "", "", ""
+	if problem.Tags == nil {
+		problem.Tags = []string{}
+	}
+	s.problems[problem.ID] = problem
+
+	tests := s.tests[problem.ID]
+	s.revisions[problem.ID] = append(s.revisions[problem.ID], memoryRevision{
+		ProblemRevision: ProblemRevision{
+			ID:        s.nextID("problem_revisions", 0),
+			ProblemID: problem.ID,
+			Revision:  problem.Revision,
+			Author:    author,
+			CreatedAt: time.Now(),
+			TestCount: len(tests),
+		},
+		problem: problem,
+		tests:   tests,
+	})
+	return nil
+}
+
+func (s *MemoryStore) GetProblemByID(id uint) (Problem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	problem, ok := s.problems[id]
+	if !ok {
+		return Problem{}, gorm.ErrRecordNotFound
+	}
+	err := setProblemTestFiles(&problem, s.tests[id])
+	return problem, err
+}
+
+func (s *MemoryStore) ListProblems(filter ProblemFilter, opts ListOptions) ([]Problem, Page, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return memoryPage(withoutHiddenCode(s.problemsWhere(s.problemMatcher(filter))), opts, problemOrder)
+}
+
+// problemMatcher returns whether a problem matches filter
+func (s *MemoryStore) problemMatcher(filter ProblemFilter) func(Problem) bool {
+	terms := searchTerms(filter.Query)
+	return func(problem Problem) bool {
+		switch {
+		case filter.Practice && problem.ContestID != 0,
+			filter.ContestID != 0 && problem.ContestID != filter.ContestID,
+			filter.Difficulty != "" && !strings.EqualFold(problem.Difficulty, filter.Difficulty),
+			filter.MinPoints != nil && problem.Points < *filter.MinPoints,
+			filter.MaxPoints != nil && problem.Points > *filter.MaxPoints,
+			filter.SolvedBy != "" && !s.hasPassed(filter.SolvedBy, problem.ID),
+			filter.UnsolvedBy != "" && s.hasPassed(filter.UnsolvedBy, problem.ID):
+			return false
+		}
+		for _, tag := range filter.Tags {
+			if !slices.Contains(problem.Tags, tag) {
+				return false
+			}
+		}
+		text := strings.ToLower(problem.Title + " " + problem.Description)
+		for _, term := range terms {
+			if !strings.Contains(text, term) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func (s *MemoryStore) hasPassed(userID string, problemID uint) bool {
+	for _, submission := range s.submissions {
+		if submission.UserID == userID && submission.ProblemID == problemID && submission.Status == StatusPassed {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutHiddenCode blanks what the GORM store leaves out of lists, see
+// hiddenProblemColumns
+func withoutHiddenCode(problems []Problem) []Problem {
+	for i := range problems {
+		problems[i].Validator, problems[i].RunnerCode, problems[i].TemplatesJSON = "", "", ""
+	}
+	return problems
+}
+
+func (s *MemoryStore) problemsWhere(match func(Problem) bool) []Problem {
+	var problems []Problem
+	for _, problem := range s.problems {
+		if match(problem) {
+			problems = append(problems, problem)
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].ID < problems[j].ID })
+	return problems
+}
+
+func (s *MemoryStore) UpdateProblem(problem Problem, author string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	problem.ID = s.nextID("problems", problem.ID)
+	problem.Revision = len(s.revisions[problem.ID]) + 1
+	return s.saveProblem(problem, hasTestData(problem), author)
+}
+
+func (s *MemoryStore) DeleteProblem(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.problems, id)
+	delete(s.tests, id)
+	s.deleteCachedVerdicts(id)
+	return nil
+}
+
+func (s *MemoryStore) GetProblemRevisions(problemID uint) ([]ProblemRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var revisions []ProblemRevision
+	for _, revision := range s.revisions[problemID] {
+		revisions = append(revisions, revision.ProblemRevision)
+	}
+	return revisions, nil
+}
+
+func (s *MemoryStore) GetProblemRevision(problemID uint, revision int) (Problem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revisions := s.revisions[problemID]
+	if revision < 1 || revision > len(revisions) {
+		return Problem{}, gorm.ErrRecordNotFound
+	}
+	problem := revisions[revision-1].problem
+	err := setProblemTestFiles(&problem, revisions[revision-1].tests)
+	return problem, err
+}
+
+func (s *MemoryStore) RollbackProblem(problemID uint, revision int, author string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revisions := s.revisions[problemID]
+	current, ok := s.problems[problemID]
+	if !ok || revision < 1 || revision > len(revisions) {
+		return gorm.ErrRecordNotFound
+	}
+	problem := revisions[revision-1].problem
+	problem.ContestID, problem.OwnerID = current.ContestID, current.OwnerID
+	problem.Revision = len(revisions) + 1
+	s.tests[problemID] = revisions[revision-1].tests
+	s.deleteCachedVerdicts(problemID)
+	return s.saveProblem(problem, false, author)
+}
+
+func (s *MemoryStore) deleteCachedVerdicts(problemID uint) {
+	for key, cached := range s.verdicts {
+		if cached.ProblemID == problemID {
+			delete(s.verdicts, key)
+		}
+	}
+}
+
+func (s *MemoryStore) RegisterForContest(userID string, contestID uint, extraInfo string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registrations = append(s.registrations, Registration{
+		ID:           s.nextID("registrations", 0),
+		UserID:       userID,
+		ContestID:    contestID,
+		RegisteredAt: time.Now(),
+		ExtraInfo:    extraInfo,
+	})
+	return nil
+}
+
+func (s *MemoryStore) registrationsWhere(match func(Registration) bool) []Registration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var registrations []Registration
+	for _, registration := range s.registrations {
+		if match(registration) {
+			registrations = append(registrations, registration)
+		}
+	}
+	return registrations
+}
+
+func (s *MemoryStore) IsUserRegistered(userID string, contestID uint) (bool, error) {
+	registrations := s.registrationsWhere(func(r Registration) bool { return r.UserID == userID && r.ContestID == contestID })
+	return len(registrations) > 0, nil
+}
+
+func (s *MemoryStore) GetContestRegistrationsCount(contestID uint) (int64, error) {
+	registrations := s.registrationsWhere(func(r Registration) bool { return r.ContestID == contestID })
+	return int64(len(registrations)), nil
+}
+
+func (s *MemoryStore) CountContestRegistrations(contestIDs []uint) (map[uint]int64, error) {
+	counts := make(map[uint]int64)
+	for _, registration := range s.registrationsWhere(func(r Registration) bool { return slices.Contains(contestIDs, r.ContestID) }) {
+		counts[registration.ContestID]++
+	}
+	return counts, nil
+}
+
+func (s *MemoryStore) ListContestRegistrations(contestID uint, opts ListOptions) ([]Registration, Page, error) {
+	registrations := s.registrationsWhere(func(r Registration) bool { return r.ContestID == contestID })
+	return memoryPage(registrations, opts, registrationOrder)
+}
+
+func (s *MemoryStore) GetRegisteredContests(userIDs []string) (map[string][]uint, error) {
+	contests := make(map[string][]uint)
+	for _, registration := range s.registrationsWhere(func(r Registration) bool { return slices.Contains(userIDs, r.UserID) }) {
+		contests[registration.UserID] = append(contests[registration.UserID], registration.ContestID)
+	}
+	return contests, nil
+}
+
+func (s *MemoryStore) GetCachedVerdict(key string) (Verdict, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cached, ok := s.verdicts[key]
+	if !ok {
+		return Verdict{}, false, nil
+	}
+	var verdict Verdict
+	if err := json.Unmarshal([]byte(cached.VerdictJSON), &verdict); err != nil {
+		return Verdict{}, false, err
+	}
+	return verdict, true, nil
+}
+
+func (s *MemoryStore) SaveCachedVerdict(key string, problemID uint, verdict Verdict) error {
+	data, err := json.Marshal(verdict)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.verdicts[key] = CachedVerdict{Key: key, ProblemID: problemID, VerdictJSON: string(data), CreatedAt: time.Now()}
+	return nil
+}
+
+func (s *MemoryStore) CreateSubmission(submission *Submission) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	submission.ID = s.nextID("submissions", submission.ID)
+	s.submissions[submission.ID] = *submission
+	return nil
+}
+
+func (s *MemoryStore) UpdateSubmission(submission Submission) error {
+	return s.CreateSubmission(&submission)
+}
+
+func (s *MemoryStore) GetSubmissionByID(id uint) (Submission, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	submission, ok := s.submissions[id]
+	if !ok {
+		return Submission{}, gorm.ErrRecordNotFound
+	}
+	return submission, nil
+}
+
+// submissionsWhere returns the matching submissions, oldest first
+func (s *MemoryStore) submissionsWhere(match func(Submission) bool) []Submission {
+	var submissions []Submission
+	for _, submission := range s.submissions {
+		if match(submission) {
+			submissions = append(submissions, submission)
+		}
+	}
+	sort.Slice(submissions, func(i, j int) bool { return submissions[i].ID < submissions[j].ID })
+	return submissions
+}
+
+func (s *MemoryStore) FindSubmissions(filter SubmissionFilter) ([]Submission, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.submissionsWhere(func(submission Submission) bool {
+		if filter.SubmissionID != 0 && submission.ID != filter.SubmissionID {
+			return false
+		}
+		if filter.ProblemID != 0 && submission.ProblemID != filter.ProblemID {
+			return false
+		}
+		if filter.UserID != "" && submission.UserID != filter.UserID {
+			return false
+		}
+		if filter.ContestID != 0 {
+			problem, ok := s.problems[submission.ProblemID]
+			return ok && problem.ContestID == filter.ContestID
+		}
+		return true
+	}), nil
+}
+
+func (s *MemoryStore) GetAcceptedSubmissions(problemID uint) ([]Submission, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	submissions := s.submissionsWhere(func(submission Submission) bool {
+		return submission.ProblemID == problemID && submission.Status == StatusPassed && submission.Source != ""
+	})
+	sort.Slice(submissions, func(i, j int) bool { return submissions[i].ID > submissions[j].ID })
+	return latestPerUser(submissions), nil
+}
+
+// solvedPoints returns the points of each problem every user has solved,
+// counting a problem once however many times it was solved
+func (s *MemoryStore) solvedPoints(match func(Problem) bool) map[string]int {
+	type userProblem struct {
+		userID    string
+		problemID uint
+	}
+	scores := make(map[string]int)
+	solved := make(map[userProblem]bool)
+	for _, submission := range s.submissions {
+		problem, ok := s.problems[submission.ProblemID]
+		if submission.Status != StatusPassed || !ok || !match(problem) {
+			continue
+		}
+		key := userProblem{submission.UserID, problem.ID}
+		if !solved[key] {
+			solved[key] = true
+			scores[submission.UserID] += problem.Points
+		}
+	}
+	return scores
+}
+
+func (s *MemoryStore) GetLeaderboard(opts ListOptions) ([]LeaderboardEntry, Page, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var leaderboard []LeaderboardEntry
+	for user, score := range s.solvedPoints(func(Problem) bool { return true }) {
+		leaderboard = append(leaderboard, LeaderboardEntry{UserID: user, Score: score})
+	}
+	return memoryPage(leaderboard, opts, leaderboardOrder)
+}
+
+func (s *MemoryStore) GetContestLeaderboard(contestID uint) ([]LeaderboardEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Only registered users are ranked, including those who solved nothing
+	scores := make(map[string]int)
+	for _, registration := range s.registrations {
+		if registration.ContestID == contestID {
+			scores[registration.UserID] = 0
+		}
+	}
+	for user, score := range s.solvedPoints(func(problem Problem) bool { return problem.ContestID == contestID }) {
+		if _, ok := scores[user]; ok {
+			scores[user] = score
+		}
+	}
+
+	var leaderboard []LeaderboardEntry
+	for user, score := range scores {
+		leaderboard = append(leaderboard, LeaderboardEntry{UserID: user, Score: score})
+	}
+	sortLeaderboard(leaderboard)
+	return leaderboard, nil
+}
+
+func sortLeaderboard(leaderboard []LeaderboardEntry) {
+	sort.Slice(leaderboard, func(i, j int) bool {
+		if leaderboard[i].Score != leaderboard[j].Score {
+			return leaderboard[i].Score > leaderboard[j].Score
+		}
+		return leaderboard[i].UserID < leaderboard[j].UserID
+	})
+}
+
+func (s *MemoryStore) GetProblemLeaderboard(problemID uint) ([]ProblemLeaderboardEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	submissions := s.submissionsWhere(func(submission Submission) bool {
+		return submission.ProblemID == problemID && submission.Status == StatusPassed
+	})
+	sort.SliceStable(submissions, func(i, j int) bool { return submissions[i].CreatedAt.Before(submissions[j].CreatedAt) })
+	entries := make([]ProblemLeaderboardEntry, len(submissions))
+	for i, submission := range submissions {
+		entries[i] = ProblemLeaderboardEntry{UserID: submission.UserID, CreatedAt: submission.CreatedAt, Status: submission.Status}
+	}
+	return entries, nil
+}
+
+func (s *MemoryStore) RecordAuditEvent(event AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event.ID = s.nextID("audit_events", event.ID)
+	s.audit = append(s.audit, event)
+	return nil
+}
+
+func (s *MemoryStore) FindAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []AuditEvent
+	for i := len(s.audit) - 1; i >= 0 && (filter.Limit <= 0 || len(events) < filter.Limit); i-- {
+		event := s.audit[i]
+		if (filter.Actor != "" && event.Actor != filter.Actor) ||
+			(filter.Action != "" && event.Action != filter.Action) ||
+			(filter.EntityType != "" && event.EntityType != filter.EntityType) ||
+			(filter.EntityID != "" && event.EntityID != filter.EntityID) ||
+			(!filter.Since.IsZero() && event.CreatedAt.Before(filter.Since)) ||
+			(!filter.Until.IsZero() && !event.CreatedAt.Before(filter.Until)) {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,643 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is one numbered step of the database schema. Up and Down run in
+// a transaction together with the update of schema_migrations.
+//
+// Migrations declare their own copies of the models they touch, so they keep
+// doing the same thing after the models in models.go change. Never edit a
+// migration that has shipped; add a new one.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied migration
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// migrations must stay sorted by Version
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up: func(tx *gorm.DB) error {
+			// Databases from before migrations were added already have
+			// these tables; AutoMigrate only adds what is missing
+			return tx.AutoMigrate(initialTables()...)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(initialTables()...)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "move inline test data to the blob store",
+		Up:      moveInlineTestsUp,
+		Down:    moveInlineTestsDown,
+	},
+	{
+		Version: 3,
+		Name:    "audit log",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&auditEventV3{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&auditEventV3{})
+		},
+	},
+	{
+		Version: 4,
+		Name:    "problem revisions",
+		Up:      problemRevisionsUp,
+		Down:    problemRevisionsDown,
+	},
+	{
+		Version: 5,
+		Name:    "problem tags and text search",
+		Up:      problemSearchUp,
+		Down:    problemSearchDown,
+	},
+}
+
+// initialTables is the schema as it was when migrations were introduced
+func initialTables() []interface{} {
+	type User struct {
+		Username string `gorm:"primaryKey"`
+		Email    string
+		Password string
+		Role     string `gorm:"default:contestant"`
+	}
+	type ContestStaff struct {
+		ID        uint   `gorm:"primaryKey"`
+		ContestID uint   `gorm:"uniqueIndex:idx_contest_staff"`
+		Username  string `gorm:"uniqueIndex:idx_contest_staff"`
+	}
+	type Session struct {
+		TokenHash string `gorm:"primaryKey"`
+		Username  string `gorm:"index"`
+		CreatedAt time.Time
+		ExpiresAt time.Time
+	}
+	type Contest struct {
+		ID                 uint `gorm:"primaryKey"`
+		Title              string
+		Description        string
+		StartTime          time.Time
+		EndTime            time.Time
+		RegistrationConfig string
+	}
+	type Registration struct {
+		ID           uint `gorm:"primaryKey"`
+		UserID       string
+		ContestID    uint
+		RegisteredAt time.Time
+		ExtraInfo    string
+	}
+	type Problem struct {
+		ID            uint `gorm:"primaryKey"`
+		ContestID     uint
+		Title         string
+		Description   string
+		Input         string
+		Output        string
+		Template      string
+		RunnerCode    string
+		Difficulty    string
+		Points        int
+		SignatureJSON string
+		TestCasesJSON string
+		Validator     string
+		TemplatesJSON string
+		OutputLimitKB int
+		OwnerID       string
+	}
+	type TestCase struct {
+		ID         uint `gorm:"primaryKey"`
+		ProblemID  uint `gorm:"index"`
+		Position   int
+		InputHash  string
+		OutputHash string
+		InputSize  int
+		OutputSize int
+	}
+	type CachedVerdict struct {
+		Key         string `gorm:"primaryKey"`
+		ProblemID   uint   `gorm:"index"`
+		VerdictJSON string
+		CreatedAt   time.Time
+	}
+	type Submission struct {
+		ID          uint `gorm:"primaryKey"`
+		UserID      string
+		ProblemID   uint
+		Status      string
+		CreatedAt   time.Time
+		Language    string
+		Source      string
+		PassedCount int
+		TotalCount  int
+		JudgedAt    time.Time
+	}
+	return []interface{}{
+		&User{}, &Contest{}, &Problem{}, &Registration{}, &Submission{},
+		&TestCase{}, &CachedVerdict{}, &Session{}, &ContestStaff{},
+	}
+}
+
+// inlineTestColumns are the problems columns test data was kept in before
+// it moved to the blob store
+type inlineTestColumns struct {
+	Input         string
+	Output        string
+	TestCasesJSON string
+}
+
+func (inlineTestColumns) TableName() string { return "problems" }
+
+// inlineTestCase is a test_cases row as written by migration 2
+type inlineTestCase struct {
+	ID         uint `gorm:"primaryKey"`
+	ProblemID  uint
+	Position   int
+	InputHash  string
+	OutputHash string
+	InputSize  int
+	OutputSize int
+}
+
+func (inlineTestCase) TableName() string { return "test_cases" }
+
+func moveInlineTestsUp(tx *gorm.DB) error {
+	if !tx.Migrator().HasColumn(&inlineTestColumns{}, "TestCasesJSON") {
+		return nil
+	}
+	var rows []struct {
+		ID            uint
+		SignatureJSON string
+		Input         string
+		Output        string
+		TestCasesJSON string
+	}
+	err := tx.Table("problems").
+		Select("id, COALESCE(signature_json, '') AS signature_json, COALESCE(input, '') AS input, " +
+			"COALESCE(output, '') AS output, COALESCE(test_cases_json, '') AS test_cases_json").
+		Where("input <> '' OR output <> '' OR test_cases_json <> ''").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		tests, err := problemTestFiles(Problem{
+			SignatureJSON: row.SignatureJSON,
+			Input:         row.Input,
+			Output:        row.Output,
+			TestCasesJSON: row.TestCasesJSON,
+		})
+		if err != nil {
+			return fmt.Errorf("problem %d: %v", row.ID, err)
+		}
+		if err := tx.Where("problem_id = ?", row.ID).Delete(&inlineTestCase{}).Error; err != nil {
+			return err
+		}
+		for i, test := range tests {
+			inputHash, err := PutBlob([]byte(test.Input))
+			if err != nil {
+				return fmt.Errorf("problem %d: %v", row.ID, err)
+			}
+			outputHash, err := PutBlob([]byte(test.Output))
+			if err != nil {
+				return fmt.Errorf("problem %d: %v", row.ID, err)
+			}
+			testCase := inlineTestCase{
+				ProblemID:  row.ID,
+				Position:   i + 1,
+				InputHash:  inputHash,
+				OutputHash: outputHash,
+				InputSize:  len(test.Input),
+				OutputSize: len(test.Output),
+			}
+			if err := tx.Create(&testCase).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	// Not Migrator().DropColumn: on SQLite it rebuilds the table from its
+	// parsed DDL, which misses columns of tables not created by GORM
+	for _, column := range []string{"input", "output", "test_cases_json"} {
+		if err := tx.Exec("ALTER TABLE problems DROP COLUMN " + column).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveInlineTestsDown copies the tests back into the problems table. The
+// blobs and test_cases rows are left alone; older versions ignore them.
+func moveInlineTestsDown(tx *gorm.DB) error {
+	for _, column := range []string{"Input", "Output", "TestCasesJSON"} {
+		if err := tx.Migrator().AddColumn(&inlineTestColumns{}, column); err != nil {
+			return err
+		}
+	}
+
+	var problems []struct {
+		ID            uint
+		SignatureJSON string
+	}
+	if err := tx.Table("problems").Select("id, COALESCE(signature_json, '') AS signature_json").Scan(&problems).Error; err != nil {
+		return err
+	}
+	for _, row := range problems {
+		var testCases []inlineTestCase
+		if err := tx.Where("problem_id = ?", row.ID).Order("position").Find(&testCases).Error; err != nil {
+			return err
+		}
+		tests := make([]IOTest, len(testCases))
+		for i, testCase := range testCases {
+			input, err := GetBlob(testCase.InputHash)
+			if err != nil {
+				return fmt.Errorf("problem %d: %v", row.ID, err)
+			}
+			output, err := GetBlob(testCase.OutputHash)
+			if err != nil {
+				return fmt.Errorf("problem %d: %v", row.ID, err)
+			}
+			tests[i] = IOTest{Input: string(input), Output: string(output)}
+		}
+		problem := Problem{SignatureJSON: row.SignatureJSON}
+		if err := setProblemTestFiles(&problem, tests); err != nil {
+			return fmt.Errorf("problem %d: %v", row.ID, err)
+		}
+		err := tx.Table("problems").Where("id = ?", row.ID).Updates(map[string]interface{}{
+			"input":           problem.Input,
+			"output":          problem.Output,
+			"test_cases_json": problem.TestCasesJSON,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type auditEventV3 struct {
+	ID         uint   `gorm:"primaryKey"`
+	Actor      string `gorm:"index"`
+	Action     string `gorm:"index"`
+	EntityType string `gorm:"index:idx_audit_entity"`
+	EntityID   string `gorm:"index:idx_audit_entity"`
+	DiffJSON   string
+	IP         string
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+func (auditEventV3) TableName() string { return "audit_events" }
+
+type problemRevisionV4 struct {
+	ID          uint `gorm:"primaryKey"`
+	ProblemID   uint `gorm:"uniqueIndex:idx_problem_revision"`
+	Revision    int  `gorm:"uniqueIndex:idx_problem_revision"`
+	Author      string
+	CreatedAt   time.Time
+	ProblemJSON string
+	TestsJSON   string
+	TestCount   int
+}
+
+func (problemRevisionV4) TableName() string { return "problem_revisions" }
+
+// problemV4 is a problems row as of migration 4. Its JSON form is what
+// revisions store.
+type problemV4 struct {
+	ID            uint   `gorm:"primaryKey" json:"id"`
+	ContestID     uint   `json:"contest_id"`
+	Title         string `json:"title"`
+	Description   string `json:"description"`
+	Template      string `json:"template"`
+	RunnerCode    string `json:"runner_code"`
+	Difficulty    string `json:"difficulty"`
+	Points        int    `json:"points"`
+	SignatureJSON string `json:"signature_json"`
+	Validator     string `json:"validator"`
+	TemplatesJSON string `json:"templates_json"`
+	OutputLimitKB int    `json:"output_limit_kb"`
+	OwnerID       string `json:"owner_id"`
+	Revision      int    `json:"revision"`
+}
+
+func (problemV4) TableName() string { return "problems" }
+
+type submissionV4 struct {
+	ProblemRevision int
+}
+
+func (submissionV4) TableName() string { return "submissions" }
+
+// problemRevisionsUp adds the revision columns and records every existing
+// problem, as it is now, as its revision 1
+func problemRevisionsUp(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&problemRevisionV4{}); err != nil {
+		return err
+	}
+	if err := tx.Migrator().AddColumn(&problemV4{}, "Revision"); err != nil {
+		return err
+	}
+	if err := tx.Migrator().AddColumn(&submissionV4{}, "ProblemRevision"); err != nil {
+		return err
+	}
+
+	var problems []problemV4
+	if err := tx.Find(&problems).Error; err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		var testCases []inlineTestCase
+		if err := tx.Where("problem_id = ?", problem.ID).Order("position").Find(&testCases).Error; err != nil {
+			return err
+		}
+		tests := make([]RevisionTest, len(testCases))
+		for i, testCase := range testCases {
+			tests[i] = RevisionTest{
+				InputHash:  testCase.InputHash,
+				OutputHash: testCase.OutputHash,
+				InputSize:  testCase.InputSize,
+				OutputSize: testCase.OutputSize,
+			}
+		}
+		problem.Revision = 1
+		problemJSON, err := json.Marshal(problem)
+		if err != nil {
+			return err
+		}
+		testsJSON, err := json.Marshal(tests)
+		if err != nil {
+			return err
+		}
+		err = tx.Create(&problemRevisionV4{
+			ProblemID:   problem.ID,
+			Revision:    1,
+			Author:      problem.OwnerID,
+			CreatedAt:   time.Now(),
+			ProblemJSON: string(problemJSON),
+			TestsJSON:   string(testsJSON),
+			TestCount:   len(tests),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Table("problems").Where("1 = 1").Update("revision", 1).Error
+}
+
+func problemRevisionsDown(tx *gorm.DB) error {
+	if err := tx.Migrator().DropTable(&problemRevisionV4{}); err != nil {
+		return err
+	}
+	// Raw DROP COLUMN for the same reason as in moveInlineTestsUp
+	for _, drop := range []string{"problems DROP COLUMN revision", "submissions DROP COLUMN problem_revision"} {
+		if err := tx.Exec("ALTER TABLE " + drop).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type problemTagV5 struct {
+	ID        uint   `gorm:"primaryKey"`
+	ProblemID uint   `gorm:"uniqueIndex:idx_problem_tag"`
+	Tag       string `gorm:"uniqueIndex:idx_problem_tag;index"`
+}
+
+func (problemTagV5) TableName() string { return "problem_tags" }
+
+// problemSearchSQLite indexes problem titles and statements with FTS5. The
+// index reads the text from problems and triggers keep it up to date.
+var problemSearchSQLite = []string{
+	`CREATE VIRTUAL TABLE problems_fts USING fts5(title, description, content='problems', content_rowid='id')`,
+	`INSERT INTO problems_fts(problems_fts) VALUES ('rebuild')`,
+	`CREATE TRIGGER problems_fts_insert AFTER INSERT ON problems BEGIN
+		INSERT INTO problems_fts(rowid, title, description) VALUES (new.id, new.title, new.description);
+	END`,
+	`CREATE TRIGGER problems_fts_delete AFTER DELETE ON problems BEGIN
+		INSERT INTO problems_fts(problems_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+	END`,
+	`CREATE TRIGGER problems_fts_update AFTER UPDATE OF title, description ON problems BEGIN
+		INSERT INTO problems_fts(problems_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+		INSERT INTO problems_fts(rowid, title, description) VALUES (new.id, new.title, new.description);
+	END`,
+}
+
+var problemSearchSQLiteDown = []string{
+	`DROP TRIGGER problems_fts_insert`,
+	`DROP TRIGGER problems_fts_delete`,
+	`DROP TRIGGER problems_fts_update`,
+	`DROP TABLE problems_fts`,
+}
+
+func problemSearchUp(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&problemTagV5{}); err != nil {
+		return err
+	}
+	statements := problemSearchSQLite
+	if tx.Dialector.Name() == "postgres" {
+		statements = []string{`CREATE INDEX idx_problems_search ON problems USING GIN ` +
+			`(to_tsvector('english', COALESCE(title, '') || ' ' || COALESCE(description, '')))`}
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func problemSearchDown(tx *gorm.DB) error {
+	statements := problemSearchSQLiteDown
+	if tx.Dialector.Name() == "postgres" {
+		statements = []string{`DROP INDEX idx_problems_search`}
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Migrator().DropTable(&problemTagV5{})
+}
+
+// LatestSchemaVersion is the version this build expects
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// appliedMigrations returns the applied migrations by version
+func appliedMigrations(db *gorm.DB) (map[int]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// SchemaVersion returns the highest applied migration, 0 for an empty database
+func SchemaVersion(db *gorm.DB) (int, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for v := range applied {
+		version = max(version, v)
+	}
+	return version, nil
+}
+
+// checkSchemaVersion refuses a database migrated by a newer build, whose
+// schema this one doesn't know how to use
+func checkSchemaVersion(db *gorm.DB) error {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > LatestSchemaVersion() {
+		return fmt.Errorf("database schema is at version %d but this build only knows up to %d; upgrade the server or run its `migrate down`", version, LatestSchemaVersion())
+	}
+	return nil
+}
+
+// MigrateUp applies every pending migration in order
+func MigrateUp(db *gorm.DB) error {
+	if err := checkSchemaVersion(db); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %v", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDown rolls back the latest applied migration
+func MigrateDown(db *gorm.DB) error {
+	if err := checkSchemaVersion(db); err != nil {
+		return err
+	}
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		return fmt.Errorf("no migrations to roll back")
+	}
+	for _, migration := range migrations {
+		if migration.Version != version {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, migration.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("rolling back migration %d (%s): %v", migration.Version, migration.Name, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("migration %d not found", version)
+}
+
+// runMigrateCommand implements `code-judge migrate status|up|down [steps]`
+func runMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate status|up|down [steps]")
+	}
+	InitBlobStore()
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		applied, err := appliedMigrations(db)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			state := "pending"
+			if row, ok := applied[migration.Version]; ok {
+				state = "applied " + row.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-45s %s\n", migration.Version, migration.Name, state)
+		}
+		for version, row := range applied {
+			if version > LatestSchemaVersion() {
+				fmt.Printf("%4d  %-45s applied by a newer build\n", version, row.Name)
+			}
+		}
+		return nil
+	case "up":
+		if err := MigrateUp(db); err != nil {
+			return err
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		for i := 0; i < steps; i++ {
+			if err := MigrateDown(db); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("schema is at version %d\n", version)
+	return nil
+}
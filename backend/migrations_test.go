@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func schemaVersion(t *testing.T, db *gorm.DB) int {
+	t.Helper()
+	version, err := SchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestMigrateUpDownUp(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		t.Setenv("BLOB_STORE_DIR", t.TempDir())
+		migrate := func(args ...string) {
+			t.Helper()
+			if err := runMigrateCommand(args); err != nil {
+				t.Fatalf("migrate %s: %v", strings.Join(args, " "), err)
+			}
+		}
+		latest := LatestSchemaVersion()
+
+		migrate("up")
+		if v := schemaVersion(t, db); v != latest {
+			t.Fatalf("schema at version %d after migrate up, want %d", v, latest)
+		}
+		stores := NewGormStores(db)
+		problem := Problem{Title: "Echo", Input: "1\n", Output: "1\n"}
+		if err := stores.Problems.CreateProblem(&problem, "alice"); err != nil {
+			t.Fatal(err)
+		}
+
+		// Back to the schema from before migrations and forward again, which
+		// moves the tests out of the blob store and back
+		migrate("down", strconv.Itoa(latest-1))
+		if v := schemaVersion(t, db); v != 1 {
+			t.Fatalf("schema at version %d after migrate down %d, want 1", v, latest-1)
+		}
+		migrate("status")
+		migrate("up")
+		if v := schemaVersion(t, db); v != latest {
+			t.Fatalf("schema at version %d after migrating up again, want %d", v, latest)
+		}
+		got, err := stores.Problems.GetProblemByID(problem.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != "Echo" || got.Input != "1\n" || got.Output != "1\n" {
+			t.Errorf("problem after down and up is %+v", got)
+		}
+		problems, _, err := stores.Problems.ListProblems(ProblemFilter{Query: "echo"}, ListOptions{Sort: "id"})
+		if err != nil || len(problems) != 1 {
+			t.Errorf("searching after down and up found %v, %v", problems, err)
+		}
+
+		// All the way down leaves nothing behind to trip up the way up
+		migrate("down", strconv.Itoa(latest))
+		if v := schemaVersion(t, db); v != 0 {
+			t.Fatalf("schema at version %d after rolling everything back", v)
+		}
+		if err := runMigrateCommand([]string{"down"}); err == nil {
+			t.Error("migrate down of an empty schema succeeded")
+		}
+		migrate("up")
+		if v := schemaVersion(t, db); v != latest {
+			t.Fatalf("schema at version %d after migrating an empty database, want %d", v, latest)
+		}
+	})
+}
+
+func TestNewerSchemaIsRefused(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		if err := MigrateUp(db); err != nil {
+			t.Fatal(err)
+		}
+		newer := SchemaMigration{Version: LatestSchemaVersion() + 1, Name: "from the future", AppliedAt: time.Now()}
+		if err := db.Create(&newer).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		for name, migrate := range map[string]func(*gorm.DB) error{"up": MigrateUp, "down": MigrateDown} {
+			err := migrate(db)
+			if err == nil || !strings.Contains(err.Error(), "only knows up to") {
+				t.Errorf("migrate %s of a newer schema: %v, want it refused", name, err)
+			}
+		}
+		if v := schemaVersion(t, db); v != newer.Version {
+			t.Errorf("schema at version %d, want it left at %d", v, newer.Version)
+		}
+		if _, err := OpenStores(); err == nil {
+			t.Error("OpenStores accepted a newer schema")
+		}
+	})
+}
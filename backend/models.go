@@ -7,23 +7,41 @@ import (
 type User struct {
 	Username string `gorm:"primaryKey"`
 	Email    string
-	Password string
+	Password string `json:"-"` // bcrypt hash; older rows may still be plaintext until the next login
+	Role     string `gorm:"default:contestant"`
+}
+
+// ContestStaff lets a user manage one contest: edit it and its problems and
+// see its registrations
+type ContestStaff struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	ContestID uint   `gorm:"uniqueIndex:idx_contest_staff" json:"contest_id"`
+	Username  string `gorm:"uniqueIndex:idx_contest_staff" json:"username"`
+}
+
+// Session is a login. The token itself is only given to the client; the
+// database keeps its SHA-256.
+type Session struct {
+	TokenHash string `gorm:"primaryKey"`
+	Username  string `gorm:"index"`
+	CreatedAt time.Time
+	ExpiresAt time.Time
 }
 
 type Contest struct {
-	ID          uint      `gorm:"primaryKey" json:"id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	StartTime   time.Time `json:"start_time"`
-	EndTime     time.Time `json:"end_time"`
-	Problems    []Problem `json:"problems"`
-	RegistrationConfig string `json:"registration_config"` // e.g. "Team Name, University"
+	ID                 uint      `gorm:"primaryKey" json:"id"`
+	Title              string    `json:"title"`
+	Description        string    `json:"description"`
+	StartTime          time.Time `json:"start_time"`
+	EndTime            time.Time `json:"end_time"`
+	Problems           []Problem `json:"problems"`
+	RegistrationConfig string    `json:"registration_config"` // e.g. "Team Name, University"
 }
 
 type Registration struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	UserID    string    `json:"user_id"`
-	ContestID uint      `json:"contest_id"`
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       string    `json:"user_id"`
+	ContestID    uint      `json:"contest_id"`
 	RegisteredAt time.Time `json:"registered_at"`
 	ExtraInfo    string    `json:"extra_info"` // JSON or text provided by user
 }
@@ -33,24 +51,119 @@ type Problem struct {
 	ContestID   uint   `json:"contest_id"`
 	Title       string `json:"title"`
 	Description string `json:"description"`
-	Input       string `json:"input"`    // Test case input
-	Output      string `json:"output"`   // Expected output
-	Template    string `json:"template"` // Starter code
-	RunnerCode  string `json:"runner_code"` // Hidden code to feed input
+	Input       string `gorm:"-" json:"input"`  // Test case input
+	Output      string `gorm:"-" json:"output"` // Expected output
+	Template    string `json:"template"`        // Starter code
+	RunnerCode  string `json:"runner_code"`     // Hidden code to feed input
 	Difficulty  string `json:"difficulty"`
 	Points      int    `json:"points"`
-	
+
 	// New LeetCode-style fields
-	SignatureJSON string `json:"signature_json"` // Stores ProblemSignature as JSON
-	TestCasesJSON string `json:"test_cases_json"` // Stores []TestCase as JSON
+	SignatureJSON string `json:"signature_json"`           // Stores ProblemSignature as JSON
+	TestCasesJSON string `gorm:"-" json:"test_cases_json"` // Stores []TestCase as JSON
+
+	Validator string `json:"validator"` // Python program that checks one test input read from stdin
+
+	TemplatesJSON string `json:"templates_json"` // Setter overrides of generated starter code, language -> code
+
+	OutputLimitKB int `json:"output_limit_kb"` // Max stdout per run, 0 for the default
+
+	OwnerID string `json:"owner_id"` // Username of the setter who created it
+
+	Revision int `json:"revision"` // Current ProblemRevision, set by the store on every save
+
+	Tags []string `gorm:"-" json:"tags"` // Lowercase, see normalizeTags; kept in problem_tags
+}
+
+// ProblemTag files a problem under a tag such as "graphs" or "dp"
+type ProblemTag struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	ProblemID uint   `gorm:"uniqueIndex:idx_problem_tag" json:"problem_id"`
+	Tag       string `gorm:"uniqueIndex:idx_problem_tag;index" json:"tag"`
+}
+
+// ProblemRevision is an immutable snapshot of a problem, taken each time it
+// is saved. Tests are kept as references to their blobs, so a revision
+// costs little beyond the problem's text.
+type ProblemRevision struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	ProblemID   uint      `gorm:"uniqueIndex:idx_problem_revision" json:"problem_id"`
+	Revision    int       `gorm:"uniqueIndex:idx_problem_revision" json:"revision"` // 1, 2, 3... per problem
+	Author      string    `json:"author"`
+	CreatedAt   time.Time `json:"created_at"`
+	ProblemJSON string    `json:"-"` // The Problem without test data
+	TestsJSON   string    `json:"-"` // []RevisionTest
+	TestCount   int       `json:"test_count"`
+}
+
+// RevisionTest references one test of a revision in the blob store
+type RevisionTest struct {
+	InputHash  string `json:"input_hash"`
+	OutputHash string `json:"output_hash"`
+	InputSize  int    `json:"input_size"`
+	OutputSize int    `json:"output_size"`
+}
+
+// TestCase points at one test of a problem in the blob store. Input, Output
+// and TestCasesJSON of a Problem are only filled in when it is loaded by ID;
+// list queries never read test data.
+type TestCase struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	ProblemID  uint   `gorm:"index" json:"problem_id"`
+	Position   int    `json:"position"`    // 1-based order the tests run in
+	InputHash  string `json:"input_hash"`  // SHA-256 of the input blob
+	OutputHash string `json:"output_hash"` // SHA-256 of the expected output blob
+	InputSize  int    `json:"input_size"`
+	OutputSize int    `json:"output_size"`
+}
+
+// CachedVerdict is the verdict of an earlier identical run, see verdictCacheKey
+type CachedVerdict struct {
+	Key         string    `gorm:"primaryKey" json:"key"`
+	ProblemID   uint      `gorm:"index" json:"problem_id"`
+	VerdictJSON string    `json:"verdict_json"`
+	CreatedAt   time.Time `json:"created_at"`
 }
 
+// AuditEvent records one administrative change. DiffJSON maps each changed
+// field to its {"before", "after"} values; see auditDiff.
+type AuditEvent struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Actor      string    `gorm:"index" json:"actor"`
+	Action     string    `gorm:"index" json:"action"` // e.g. "problem.update"
+	EntityType string    `gorm:"index:idx_audit_entity" json:"entity_type"`
+	EntityID   string    `gorm:"index:idx_audit_entity" json:"entity_id"`
+	DiffJSON   string    `json:"diff_json"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+// Submission statuses
+const (
+	StatusQueued              = "Queued"
+	StatusPassed              = "Passed"
+	StatusFailed              = "Failed"
+	StatusCompilationError    = "Compilation Error"
+	StatusRuntimeError        = "Runtime Error"
+	StatusTimeLimitExceeded   = "Time Limit Exceeded"
+	StatusOutputLimitExceeded = "Output Limit Exceeded"
+	StatusError               = "Error" // The judge itself failed, not the solution
+	StatusCanceled            = "Canceled"
+)
+
 type Submission struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	UserID    string    `json:"user_id"`
-	ProblemID uint      `json:"problem_id"`
-	Status    string    `json:"status"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      string    `json:"user_id"`
+	ProblemID   uint      `json:"problem_id"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	Language    string    `json:"language"`
+	Source      string    `json:"source"` // Stored so the submission can be rejudged
+	PassedCount int       `json:"passed_count"`
+	TotalCount  int       `json:"total_count"`
+	JudgedAt    time.Time `json:"judged_at"`
+
+	ProblemRevision int `json:"problem_revision"` // Revision of the problem it was last judged against, 0 if unknown
 }
 
 type ProblemSignature struct {
@@ -63,4 +176,3 @@ type ProblemSignature struct {
 	} `json:"parameters"`
 	ReturnType string `json:"return_type"` // e.g., "List[int]"
 }
-
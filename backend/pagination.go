@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// ListOptions sorts and pages a list. Rows are ordered by Sort, one of the
+// names the list's listOrder accepts, then by its tiebreak, and a page
+// starts after the row the previous one ended on, so rows added or removed
+// meanwhile don't shift later pages.
+type ListOptions struct {
+	Sort  string
+	Desc  bool
+	After []any // Sort value and tiebreak of the previous page's last row, nil for the first page
+	Limit int   // 0 for no limit
+}
+
+// Page is what a store reports about the page of a list it returned
+type Page struct {
+	Total int64  // Rows matching the filter, on every page
+	Next  string // Cursor of the next page, "" on the last one
+}
+
+// sortKey is something a list can be sorted by
+type sortKey[T any] struct {
+	Name   string      // As clients ask for it
+	Column string      // Its SQL expression, for GormStore
+	Value  func(T) any // Its value in a row: a string, int, uint or time.Time
+}
+
+// listOrder is how a list of T can be sorted: by one of Keys, then by
+// Tiebreak, which is unique so every row has a place to resume after
+type listOrder[T any] struct {
+	Keys     []sortKey[T]
+	Tiebreak sortKey[T]
+}
+
+func (o listOrder[T]) key(name string) (sortKey[T], error) {
+	for _, key := range o.Keys {
+		if key.Name == name {
+			return key, nil
+		}
+	}
+	return sortKey[T]{}, fmt.Errorf("cannot sort by %q", name)
+}
+
+// parseListOptions reads the paging query parameters shared by list
+// endpoints: limit, cursor (from X-Next-Cursor of the previous page) and
+// sort, one of order's keys or "-" and one of them for descending order. It
+// answers the request if they are invalid.
+func parseListOptions[T any](c *gin.Context, order listOrder[T], defaultSort string) (ListOptions, bool) {
+	opts := ListOptions{Limit: DefaultPageSize}
+
+	sort := c.DefaultQuery("sort", defaultSort)
+	opts.Sort = strings.TrimPrefix(sort, "-")
+	opts.Desc = opts.Sort != sort
+	key, err := order.key(opts.Sort)
+	if err != nil {
+		names := make([]string, len(order.Keys))
+		for i, key := range order.Keys {
+			names[i] = key.Name
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of " + strings.Join(names, ", ") + ", optionally prefixed with -"})
+		return opts, false
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(MaxPageSize)})
+			return opts, false
+		}
+		opts.Limit = limit
+	}
+	if value := c.Query("cursor"); value != "" {
+		after, err := decodeCursor(value, sort, key, order.Tiebreak)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return opts, false
+		}
+		opts.After = after
+	}
+	return opts, true
+}
+
+// setPageHeaders reports the total number of rows matching a list request in
+// X-Total-Count and, unless this was the last page, the cursor of the next
+// one in X-Next-Cursor
+func setPageHeaders(c *gin.Context, page Page) {
+	c.Header("X-Total-Count", strconv.FormatInt(page.Total, 10))
+	if page.Next != "" {
+		c.Header("X-Next-Cursor", page.Next)
+	}
+}
+
+// nextPage cuts items, fetched one past opts.Limit to tell whether there is
+// more, down to the page and returns the cursor of the next one
+func nextPage[T any](items []T, opts ListOptions, key, tiebreak sortKey[T]) ([]T, string) {
+	if opts.Limit == 0 || len(items) <= opts.Limit {
+		return items, ""
+	}
+	items = items[:opts.Limit]
+	last := items[len(items)-1]
+	sort := opts.Sort
+	if opts.Desc {
+		sort = "-" + sort
+	}
+	return items, encodeCursor(sort, key.Value(last), tiebreak.Value(last))
+}
+
+// cursor is where a page ended. It keeps the order it was made for, as a
+// position in one order means nothing in another.
+type cursor struct {
+	Sort  string            `json:"sort"`
+	After []json.RawMessage `json:"after"`
+}
+
+// Cursors are opaque to clients so what they hold can change later
+func encodeCursor(sort string, value, tiebreak any) string {
+	after := make([]json.RawMessage, 2)
+	for i, v := range []any{value, tiebreak} {
+		after[i], _ = json.Marshal(v)
+	}
+	data, _ := json.Marshal(cursor{Sort: sort, After: after})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor returns the sort value and tiebreak a cursor for sort holds,
+// as the types key and tiebreak have
+func decodeCursor[T any](value, sort string, key, tiebreak sortKey[T]) ([]any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if c.Sort != sort || len(c.After) != 2 {
+		return nil, fmt.Errorf("cursor is for another order")
+	}
+	var zero T
+	after := make([]any, 2)
+	for i, k := range []sortKey[T]{key, tiebreak} {
+		v := reflect.New(reflect.TypeOf(k.Value(zero)))
+		if err := json.Unmarshal(c.After[i], v.Interface()); err != nil {
+			return nil, err
+		}
+		after[i] = v.Elem().Interface()
+	}
+	return after, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Pages resume after the last row seen, so rows added or removed between
+// requests don't make later pages skip or repeat any
+func TestPagesResumeAfterTheirCursor(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		s := newTestServer(t, stores)
+		// Contests start in pairs, so the tiebreak decides within each
+		now := time.Now()
+		create := func(start time.Time) {
+			if err := stores.Contests.CreateContest(&Contest{Title: "Weekly", StartTime: start}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := range 6 {
+			create(now.Add(time.Duration(i/2) * time.Hour))
+		}
+
+		page := func(cursor string) ([]uint, string) {
+			t.Helper()
+			query := url.Values{"sort": {"-start_time"}, "limit": {"2"}}
+			if cursor != "" {
+				query.Set("cursor", cursor)
+			}
+			w := s.request(http.MethodGet, "/contests?"+query.Encode(), "", nil)
+			var contests []Contest
+			s.decode(w, http.StatusOK, &contests)
+			var ids []uint
+			for _, contest := range contests {
+				ids = append(ids, contest.ID)
+			}
+			return ids, w.Header().Get("X-Next-Cursor")
+		}
+
+		seen, cursor := page("")
+		if want := []uint{5, 6}; !slices.Equal(seen, want) {
+			t.Fatalf("first page %v, want %v", seen, want)
+		}
+		// One contest already seen goes away and one starts with the latest
+		if err := stores.Contests.DeleteContest(5); err != nil {
+			t.Fatal(err)
+		}
+		create(now.Add(2 * time.Hour))
+		for cursor != "" {
+			var ids []uint
+			ids, cursor = page(cursor)
+			seen = append(seen, ids...)
+		}
+		if want := []uint{5, 6, 7, 3, 4, 1, 2}; !slices.Equal(seen, want) {
+			t.Errorf("contests %v, want %v", seen, want)
+		}
+
+		_, cursor = page("")
+		s.decode(s.request(http.MethodGet, "/contests?cursor=garbage", "", nil), http.StatusBadRequest, nil)
+		s.decode(s.request(http.MethodGet, "/contests?sort=title&cursor="+cursor, "", nil), http.StatusBadRequest, nil)
+	})
+}
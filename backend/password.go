@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HashPassword returns the bcrypt hash of a password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches a hash from HashPassword
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// isPasswordHash tells bcrypt hashes apart from plaintext passwords stored
+// before hashing was introduced. The prefix alone isn't enough, as an old
+// password may well start with "$2a$", so the rest must parse too.
+func isPasswordHash(stored string) bool {
+	if !strings.HasPrefix(stored, "$2a$") && !strings.HasPrefix(stored, "$2b$") && !strings.HasPrefix(stored, "$2y$") {
+		return false
+	}
+	_, err := bcrypt.Cost([]byte(stored))
+	return err == nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Winnowing parameters: fingerprints are hashes of plagiarismK consecutive
+// normalized tokens, and the minimum hash of every plagiarismW consecutive
+// k-grams is kept. Any shared run of at least K+W-1 tokens is guaranteed to
+// produce a shared fingerprint.
+const (
+	plagiarismK = 8
+	plagiarismW = 4
+)
+
+// sourceKeywords are kept verbatim by the tokenizer; every other identifier
+// is normalized so renaming variables doesn't hide copying
+var sourceKeywords = map[string]bool{
+	// Python
+	"False": true, "None": true, "True": true, "and": true, "as": true, "assert": true,
+	"break": true, "class": true, "continue": true, "def": true, "del": true, "elif": true,
+	"else": true, "except": true, "finally": true, "for": true, "from": true, "global": true,
+	"if": true, "import": true, "in": true, "is": true, "lambda": true, "nonlocal": true,
+	"not": true, "or": true, "pass": true, "raise": true, "return": true, "try": true,
+	"while": true, "with": true, "yield": true, "self": true,
+	// C-family
+	"auto": true, "case": true, "const": true, "default": true, "do": true, "new": true,
+	"public": true, "private": true, "static": true, "struct": true, "switch": true,
+	"this": true, "var": true, "let": true, "function": true, "void": true,
+}
+
+type sourceToken struct {
+	Text string
+	Line int // 1-based
+}
+
+// tokenizeSource splits source code into normalized tokens. Whitespace and
+// comments are dropped, identifiers become "V", numbers "N" and strings "S".
+func tokenizeSource(source string) []sourceToken {
+	var tokens []sourceToken
+	runes := []rune(source)
+	line := 1
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '\n':
+			line++
+			i++
+		case unicode.IsSpace(r):
+			i++
+		case r == '#' || (r == '/' && i+1 < len(runes) && runes[i+1] == '/'):
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			i += 2
+			for i < len(runes) && !(runes[i] == '*' && i+1 < len(runes) && runes[i+1] == '/') {
+				if runes[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			i += 2
+		case r == '"' || r == '\'' || r == '`':
+			start := line
+			quote := string(r)
+			if i+2 < len(runes) && runes[i+1] == r && runes[i+2] == r {
+				quote = strings.Repeat(quote, 3)
+			}
+			i += len(quote)
+			for i < len(runes) && !strings.HasPrefix(string(runes[i:min(i+len(quote), len(runes))]), quote) {
+				if runes[i] == '\\' {
+					i++
+				} else if runes[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			i += len(quote)
+			tokens = append(tokens, sourceToken{Text: "S", Line: start})
+		case unicode.IsDigit(r):
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || unicode.IsLetter(runes[i]) || runes[i] == '.' || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, sourceToken{Text: "N", Line: line})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			word := string(runes[start:i])
+			if !sourceKeywords[word] {
+				word = "V"
+			}
+			tokens = append(tokens, sourceToken{Text: word, Line: line})
+		default:
+			tokens = append(tokens, sourceToken{Text: string(r), Line: line})
+			i++
+		}
+	}
+	return tokens
+}
+
+type fingerprint struct {
+	Hash uint64
+	Pos  int // Index of the first token of the k-gram
+}
+
+// winnow selects the fingerprints of a token stream
+func winnow(tokens []sourceToken) []fingerprint {
+	if len(tokens) < plagiarismK {
+		return nil
+	}
+
+	hashes := make([]uint64, len(tokens)-plagiarismK+1)
+	for i := range hashes {
+		h := fnv.New64a()
+		for _, tok := range tokens[i : i+plagiarismK] {
+			h.Write([]byte(tok.Text))
+			h.Write([]byte{0})
+		}
+		hashes[i] = h.Sum64()
+	}
+
+	var prints []fingerprint
+	last := -1
+	windows := max(len(hashes)-plagiarismW+1, 1)
+	for start := 0; start < windows; start++ {
+		end := min(start+plagiarismW, len(hashes))
+		// Rightmost minimum, so a window sliding over a run of equal hashes
+		// keeps selecting the same position
+		minPos := start
+		for i := start; i < end; i++ {
+			if hashes[i] <= hashes[minPos] {
+				minPos = i
+			}
+		}
+		if minPos != last {
+			prints = append(prints, fingerprint{Hash: hashes[minPos], Pos: minPos})
+			last = minPos
+		}
+	}
+	return prints
+}
+
+// LineRange is an inclusive range of 1-based source lines
+type LineRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// PlagiarismMatch is a region of code shared by both submissions
+type PlagiarismMatch struct {
+	A LineRange `json:"a"`
+	B LineRange `json:"b"`
+}
+
+// PlagiarismPair is a pair of submissions with similar code
+type PlagiarismPair struct {
+	SubmissionA uint              `json:"submission_a"`
+	SubmissionB uint              `json:"submission_b"`
+	UserA       string            `json:"user_a"`
+	UserB       string            `json:"user_b"`
+	Score       float64           `json:"score"`   // Larger of ScoreA and ScoreB
+	ScoreA      float64           `json:"score_a"` // Fraction of A's fingerprints found in B
+	ScoreB      float64           `json:"score_b"` // Fraction of B's fingerprints found in A
+	Matches     []PlagiarismMatch `json:"matches"`
+	SourceA     string            `json:"source_a"`
+	SourceB     string            `json:"source_b"`
+}
+
+type plagiarismDoc struct {
+	Submission Submission
+	Tokens     []sourceToken
+	Prints     map[uint64][]int // Fingerprint hash -> token positions
+}
+
+func newPlagiarismDoc(submission Submission, boilerplate map[uint64]bool) plagiarismDoc {
+	doc := plagiarismDoc{
+		Submission: submission,
+		Tokens:     tokenizeSource(submission.Source),
+		Prints:     make(map[uint64][]int),
+	}
+	for _, fp := range winnow(doc.Tokens) {
+		if boilerplate[fp.Hash] {
+			continue
+		}
+		doc.Prints[fp.Hash] = append(doc.Prints[fp.Hash], fp.Pos)
+	}
+	return doc
+}
+
+// DetectPlagiarism compares every pair of submissions and returns the pairs
+// whose similarity is at least threshold, most similar first. Fingerprints
+// that also occur in any of the templates are treated as boilerplate and
+// don't count towards the score.
+func DetectPlagiarism(submissions []Submission, templates []string, threshold float64) []PlagiarismPair {
+	boilerplate := make(map[uint64]bool)
+	for _, template := range templates {
+		for _, fp := range winnow(tokenizeSource(template)) {
+			boilerplate[fp.Hash] = true
+		}
+	}
+
+	docs := make([]plagiarismDoc, 0, len(submissions))
+	for _, submission := range submissions {
+		docs = append(docs, newPlagiarismDoc(submission, boilerplate))
+	}
+
+	pairs := []PlagiarismPair{}
+	for i := 0; i < len(docs); i++ {
+		for j := i + 1; j < len(docs); j++ {
+			a, b := docs[i], docs[j]
+			if len(a.Prints) == 0 || len(b.Prints) == 0 {
+				continue
+			}
+
+			var shared [][2]int // Matching (posA, posB) k-gram starts
+			for hash, posA := range a.Prints {
+				if posB, ok := b.Prints[hash]; ok {
+					shared = append(shared, [2]int{posA[0], posB[0]})
+				}
+			}
+
+			scoreA := float64(len(shared)) / float64(len(a.Prints))
+			scoreB := float64(len(shared)) / float64(len(b.Prints))
+			score := max(scoreA, scoreB)
+			if len(shared) == 0 || score < threshold {
+				continue
+			}
+
+			pairs = append(pairs, PlagiarismPair{
+				SubmissionA: a.Submission.ID,
+				SubmissionB: b.Submission.ID,
+				UserA:       a.Submission.UserID,
+				UserB:       b.Submission.UserID,
+				Score:       score,
+				ScoreA:      scoreA,
+				ScoreB:      scoreB,
+				Matches:     matchRegions(a.Tokens, b.Tokens, shared),
+				SourceA:     a.Submission.Source,
+				SourceB:     b.Submission.Source,
+			})
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Score > pairs[j].Score
+	})
+	return pairs
+}
+
+// matchRegions merges matching k-grams that continue each other on both
+// sides into contiguous line ranges for side-by-side highlighting
+func matchRegions(tokensA, tokensB []sourceToken, shared [][2]int) []PlagiarismMatch {
+	sort.Slice(shared, func(i, j int) bool { return shared[i][0] < shared[j][0] })
+
+	var matches []PlagiarismMatch
+	startA, startB := shared[0][0], shared[0][1]
+	endA, endB := startA+plagiarismK-1, startB+plagiarismK-1
+	flush := func() {
+		matches = append(matches, PlagiarismMatch{
+			A: LineRange{Start: tokensA[startA].Line, End: tokensA[endA].Line},
+			B: LineRange{Start: tokensB[startB].Line, End: tokensB[endB].Line},
+		})
+	}
+
+	for _, m := range shared[1:] {
+		posA, posB := m[0], m[1]
+		// Continue the region if both sides advance together and the gap is
+		// no wider than a winnowing window
+		if posA-posB == startA-startB && posA <= endA+plagiarismW {
+			endA = max(endA, posA+plagiarismK-1)
+			endB = max(endB, posB+plagiarismK-1)
+			continue
+		}
+		flush()
+		startA, startB = posA, posB
+		endA, endB = posA+plagiarismK-1, posB+plagiarismK-1
+	}
+	flush()
+	return matches
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+const plagiarismOriginal = `def two_sum(nums, target):
+    seen = {}
+    for i, num in enumerate(nums):
+        if target - num in seen:
+            return [seen[target - num], i]
+        seen[num] = i
+    return []
+`
+
+// The same code with every identifier renamed, other comments and spacing
+const plagiarismRenamed = `# my own solution
+def solve(arr, goal):
+    index_of = {}   # value -> index
+    for j, x in enumerate(arr):
+        if goal - x in index_of:
+            return [index_of[goal - x], j]
+
+        index_of[x] = j
+    return []
+`
+
+const plagiarismUnrelated = `def reverse_words(s):
+    words = s.split()
+    words.reverse()
+    return " ".join(words)
+
+while True:
+    line = input()
+    if not line:
+        break
+    print(reverse_words(line))
+`
+
+func TestDetectPlagiarism(t *testing.T) {
+	submissions := []Submission{
+		{ID: 1, UserID: "alice", Source: plagiarismOriginal},
+		{ID: 2, UserID: "bob", Source: plagiarismRenamed},
+		{ID: 3, UserID: "carol", Source: plagiarismUnrelated},
+	}
+	pairs := DetectPlagiarism(submissions, nil, 0.5)
+	if len(pairs) != 1 {
+		t.Fatalf("%d pairs, want only alice and bob: %+v", len(pairs), pairs)
+	}
+	pair := pairs[0]
+	if pair.SubmissionA != 1 || pair.SubmissionB != 2 || pair.Score != 1 {
+		t.Errorf("pair %d and %d scored %v, want 1 and 2 identical", pair.SubmissionA, pair.SubmissionB, pair.Score)
+	}
+	if len(pair.Matches) == 0 {
+		t.Error("no matching regions")
+	}
+
+	// Below any sensible threshold, unrelated code still shares nothing
+	for _, pair := range DetectPlagiarism(submissions, nil, 0) {
+		if pair.SubmissionA == 3 || pair.SubmissionB == 3 {
+			t.Errorf("unrelated code matched with score %v", pair.Score)
+		}
+	}
+}
+
+// Code everyone was given doesn't count as copied
+func TestDetectPlagiarismIgnoresTemplates(t *testing.T) {
+	submissions := []Submission{
+		{ID: 1, UserID: "alice", Source: plagiarismOriginal},
+		{ID: 2, UserID: "bob", Source: plagiarismRenamed},
+	}
+	if pairs := DetectPlagiarism(submissions, []string{plagiarismOriginal}, 0); len(pairs) != 0 {
+		t.Errorf("template matched as plagiarism: %+v", pairs)
+	}
+}
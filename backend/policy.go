@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SandboxPolicy is the isolation applied to every run, whichever executor
+// is used. It is loaded from the JSON file named by SANDBOX_POLICY, with
+// DefaultSandboxPolicy filling in anything the file leaves out.
+type SandboxPolicy struct {
+	Network           bool              `json:"network"`             // Allow network access
+	ProcessLimit      int               `json:"process_limit"`       // Max processes/threads, stops fork bombs
+	ReadOnlyRoot      bool              `json:"read_only_root"`      // Runtime filesystem is read-only
+	ReadOnlyWorkspace bool              `json:"read_only_workspace"` // /code is read-only; only /tmp is writable
+	ScratchSize       string            `json:"scratch_size"`        // Size of the writable /tmp, e.g. "16m"
+	CapDrop           []string          `json:"cap_drop"`            // Linux capabilities to drop, "ALL" for all
+	NoNewPrivileges   bool              `json:"no_new_privileges"`
+	User              string            `json:"user"`    // "uid:gid" the program runs as
+	Ulimits           map[string]string `json:"ulimits"` // e.g. "nofile": "64:64"
+}
+
+var DefaultSandboxPolicy = SandboxPolicy{
+	Network:           false,
+	ProcessLimit:      64,
+	ReadOnlyRoot:      true,
+	ReadOnlyWorkspace: true,
+	ScratchSize:       "16m",
+	CapDrop:           []string{"ALL"},
+	NoNewPrivileges:   true,
+	User:              "65534:65534",
+	Ulimits: map[string]string{
+		"nofile": "64:64",
+		"core":   "0:0",
+	},
+}
+
+var Policy = DefaultSandboxPolicy
+
+// LoadSandboxPolicy reads the policy file, if any
+func LoadSandboxPolicy() (SandboxPolicy, error) {
+	policy := DefaultSandboxPolicy
+	policy.Ulimits = make(map[string]string)
+	for name, value := range DefaultSandboxPolicy.Ulimits {
+		policy.Ulimits[name] = value
+	}
+	path := os.Getenv("SANDBOX_POLICY")
+	if path == "" {
+		return policy, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return policy, err
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return policy, fmt.Errorf("invalid sandbox policy %s: %v", path, err)
+	}
+	if _, _, err := policy.UserIDs(); err != nil {
+		return policy, err
+	}
+	return policy, nil
+}
+
+// UserIDs parses User into a numeric uid and gid
+func (p SandboxPolicy) UserIDs() (int, int, error) {
+	uidStr, gidStr, found := strings.Cut(p.User, ":")
+	if !found {
+		gidStr = uidStr
+	}
+	uid, err := strconv.Atoi(uidStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("sandbox user must be numeric uid:gid, got %q", p.User)
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("sandbox user must be numeric uid:gid, got %q", p.User)
+	}
+	return uid, gid, nil
+}
+
+// UlimitNames returns the configured ulimit names in a stable order
+func (p SandboxPolicy) UlimitNames() []string {
+	names := make([]string, 0, len(p.Ulimits))
+	for name := range p.Ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Verdicts for programs that tried something the policy forbids
+const (
+	StatusProcessLimit     = "Process Limit Exceeded"
+	StatusNetworkDenied    = "Network Access Denied"
+	StatusFilesystemDenied = "Filesystem Access Denied"
+)
+
+// Violations counts what the sandbox refused during a run. They come from
+// the sandbox itself (the cgroup and the seccomp supervisor), never from the
+// program's output as progress markers do, so a program can't forge or hide
+// them.
+type Violations struct {
+	Forks      int // Processes the pids limit refused to create
+	Network    int // Internet sockets opened in a sandbox without a network
+	Filesystem int // Writes aimed at a read-only mount, i.e. outside the scratch directory
+}
+
+// classifyViolation returns the policy verdict for a failed run, or "" if
+// the sandbox refused nothing. A refused fork usually brings the rest down
+// with it, so it takes precedence.
+func classifyViolation(v Violations) string {
+	switch {
+	case v.Forks > 0:
+		return StatusProcessLimit
+	case v.Network > 0:
+		return StatusNetworkDenied
+	case v.Filesystem > 0:
+		return StatusFilesystemDenied
+	}
+	return ""
+}
+
+// ViolationError is a run that failed after the sandbox refused something
+type ViolationError struct {
+	Status string
+	Output string
+}
+
+func (e *ViolationError) Error() string {
+	return e.Status
+}
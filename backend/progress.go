@@ -0,0 +1,37 @@
+package main
+
+// Judging events streamed to GET /submission/:id/events
+const (
+	EventQueued    = "queued"
+	EventCompiling = "compiling"
+	EventRunning   = "running"
+	EventTest      = "test" // One test finished
+	EventFinished  = "finished"
+)
+
+// JudgeEvent reports the progress of one submission
+type JudgeEvent struct {
+	Event        string   `json:"event"`
+	SubmissionID uint     `json:"submission_id"`
+	Test         int      `json:"test,omitempty"` // 1-based index of the test that finished
+	Total        int      `json:"total,omitempty"`
+	Passed       int      `json:"passed"`           // Tests passed so far
+	Status       string   `json:"status,omitempty"` // Verdict of the test for "test", of the submission for "finished"
+	Verdict      *Verdict `json:"verdict,omitempty"`
+}
+
+// ProgressFunc receives the events of a submission being judged
+type ProgressFunc func(event JudgeEvent)
+
+// SubmissionEvents fans judging events out to the clients watching a
+// submission. It is keyed by submission ID rather than contest ID.
+var SubmissionEvents *LeaderboardBroker
+
+// startEvent is the first event of a run: compiled languages spend a while
+// compiling before any test runs
+func startEvent(lang Language, total int) JudgeEvent {
+	if lang.CompileCommand != "" {
+		return JudgeEvent{Event: EventCompiling, Total: total}
+	}
+	return JudgeEvent{Event: EventRunning, Total: total}
+}
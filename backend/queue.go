@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// JudgeJob is one submission waiting to be judged
+type JudgeJob struct {
+	Submission Submission
+	Problem    Problem
+	Rejudge    bool // Keep the previous verdict if the judge itself fails
+	Done       chan JudgeOutcome
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   func() bool // Detaches the job from the caller's context
+}
+
+// JudgeOutcome is what a worker reports back for a JudgeJob
+type JudgeOutcome struct {
+	Submission Submission // The submission as stored after judging
+	Verdict    Verdict
+	Err        error
+}
+
+// ErrQueueClosed is the outcome of a submission made after Shutdown
+var ErrQueueClosed = errors.New("the judge queue is shutting down")
+
+type JudgeQueue struct {
+	Jobs  chan *JudgeJob
+	store SubmissionStore // Where verdicts and the verdict cache are saved
+
+	ctx      context.Context // Cancelled on shutdown, parent of every job
+	shutdown context.CancelFunc
+	inflight sync.WaitGroup
+
+	mu      sync.Mutex
+	pending map[uint]*JudgeJob // Queued or running, by submission ID
+	closed  bool               // Set by Shutdown, after which Submit refuses jobs
+}
+
+var Queue *JudgeQueue
+
+// InitQueue starts the judge workers. Every run, whether a fresh submission
+// or a rejudge, goes through the queue so the number of concurrent
+// containers stays bounded.
+func InitQueue(workers int, store SubmissionStore) {
+	ctx, shutdown := context.WithCancel(context.Background())
+	Queue = &JudgeQueue{
+		Jobs:     make(chan *JudgeJob, 256),
+		store:    store,
+		ctx:      ctx,
+		shutdown: shutdown,
+		pending:  make(map[uint]*JudgeJob),
+	}
+	for i := 0; i < workers; i++ {
+		go Queue.work()
+	}
+}
+
+// Submit enqueues a submission and returns the channel its outcome will be
+// delivered on. The run is cancelled when ctx is, e.g. when the client
+// that asked for it disconnects. After Shutdown the outcome is
+// ErrQueueClosed and the submission is left as it is.
+func (q *JudgeQueue) Submit(ctx context.Context, submission Submission, problem Problem, rejudge bool) <-chan JudgeOutcome {
+	job := &JudgeJob{
+		Submission: submission,
+		Problem:    problem,
+		Rejudge:    rejudge,
+		Done:       make(chan JudgeOutcome, 1),
+	}
+
+	// Counting the job in flight under the lock keeps it from racing
+	// Shutdown's wait
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		job.Done <- JudgeOutcome{
+			Submission: submission,
+			Verdict:    Verdict{Status: StatusCanceled, FailedIndex: -1},
+			Err:        ErrQueueClosed,
+		}
+		return job.Done
+	}
+	job.ctx, job.cancel = context.WithCancel(q.ctx)
+	// Tie the job to the caller's context without making it a parent, so
+	// the queue's own cancellation still applies
+	job.stop = context.AfterFunc(ctx, job.cancel)
+	q.pending[submission.ID] = job
+	q.inflight.Add(1)
+	q.mu.Unlock()
+
+	publishEvent(JudgeEvent{Event: EventQueued, SubmissionID: submission.ID})
+	q.Jobs <- job
+	return job.Done
+}
+
+// Cancel stops a queued or running submission. It returns false if the
+// submission isn't being judged.
+func (q *JudgeQueue) Cancel(submissionID uint) bool {
+	q.mu.Lock()
+	job, ok := q.pending[submissionID]
+	q.mu.Unlock()
+	if ok {
+		job.cancel()
+	}
+	return ok
+}
+
+// Pending reports whether a submission is queued or running
+func (q *JudgeQueue) Pending(submissionID uint) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.pending[submissionID]
+	return ok
+}
+
+// Shutdown cancels every queued and running job and waits for their
+// outcomes to be recorded, so no sandbox outlives the server. Submissions
+// made after it starts are refused.
+func (q *JudgeQueue) Shutdown() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.shutdown()
+	q.inflight.Wait()
+}
+
+func (q *JudgeQueue) work() {
+	for job := range q.Jobs {
+		outcome := q.judge(job)
+
+		q.mu.Lock()
+		if q.pending[job.Submission.ID] == job {
+			delete(q.pending, job.Submission.ID)
+		}
+		q.mu.Unlock()
+		job.stop()
+		job.cancel()
+
+		job.Done <- outcome
+		q.inflight.Done()
+	}
+}
+
+func (q *JudgeQueue) judge(job *JudgeJob) JudgeOutcome {
+	submission := job.Submission
+	runID := fmt.Sprintf("submission-%d", submission.ID)
+
+	var verdict Verdict
+	lang, err := GetLanguage(submission.Language)
+	if err == nil && job.ctx.Err() == nil {
+		verdict, err = q.judgeCached(job, lang, runID)
+	} else {
+		verdict = Verdict{Status: StatusError, FailedIndex: -1}
+	}
+	if job.ctx.Err() != nil {
+		// Cancelled, not a judge failure
+		verdict = Verdict{Status: StatusCanceled, FailedIndex: -1}
+		err = nil
+	}
+	if job.Rejudge && (verdict.Status == StatusError || verdict.Status == StatusCanceled) {
+		// Don't overwrite a real verdict because the judge is broken
+		return JudgeOutcome{Submission: submission, Verdict: verdict, Err: err}
+	}
+
+	submission.Status = verdict.Status
+	submission.PassedCount = verdict.PassedCount
+	submission.TotalCount = verdict.TotalCount
+	submission.JudgedAt = time.Now()
+	submission.ProblemRevision = job.Problem.Revision
+	if saveErr := q.store.UpdateSubmission(submission); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	publishEvent(JudgeEvent{
+		Event:        EventFinished,
+		SubmissionID: submission.ID,
+		Total:        verdict.TotalCount,
+		Passed:       verdict.PassedCount,
+		Status:       verdict.Status,
+		Verdict:      &verdict,
+	})
+	return JudgeOutcome{Submission: submission, Verdict: verdict, Err: err}
+}
+
+// judgeCached returns the verdict of an identical earlier run if there is
+// one, and judges the submission otherwise. Rejudges always run, since they
+// exist to pick up changes the cache can't see.
+func (q *JudgeQueue) judgeCached(job *JudgeJob, lang Language, runID string) (Verdict, error) {
+	submission := job.Submission
+	key := verdictCacheKey(job.Problem, lang, submission.Source)
+	if !job.Rejudge {
+		if verdict, ok, _ := q.store.GetCachedVerdict(key); ok {
+			return verdict, nil
+		}
+	}
+
+	verdict, err := JudgeSolution(job.ctx, runID, job.Problem, lang, submission.Source, func(event JudgeEvent) {
+		event.SubmissionID = submission.ID
+		publishEvent(event)
+	})
+	if err == nil && job.ctx.Err() == nil && cacheableStatus(verdict.Status) {
+		q.store.SaveCachedVerdict(key, job.Problem.ID, verdict)
+	}
+	return verdict, err
+}
+
+func publishEvent(event JudgeEvent) {
+	SubmissionEvents.Broadcast(event.SubmissionID, event)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingExecutor runs nothing until the run is cancelled, telling started
+// about each run it is given
+type blockingExecutor struct {
+	started chan struct{}
+}
+
+func (b *blockingExecutor) Run(ctx context.Context, spec RunSpec) (RunOutcome, error) {
+	b.started <- struct{}{}
+	<-ctx.Done()
+	return RunOutcome{}, ctx.Err()
+}
+
+// startTestQueue starts a one-worker queue whose runs block until cancelled
+func startTestQueue(t *testing.T) (Stores, *blockingExecutor) {
+	executor := &blockingExecutor{started: make(chan struct{}, 16)}
+	useExecutor(t, executor)
+	Languages = []Language{{ID: "python", SourceFile: "solution.py", RunCommand: "python solution.py"}}
+	InitBroker()
+	stores := NewMemoryStores()
+	InitQueue(1, stores.Submissions)
+	t.Cleanup(Queue.Shutdown)
+	return stores, executor
+}
+
+func submitTest(t *testing.T, stores Stores) (Submission, <-chan JudgeOutcome) {
+	t.Helper()
+	submission := Submission{UserID: "alice", Language: "python", Source: "print(1)", Status: StatusQueued}
+	if err := stores.Submissions.CreateSubmission(&submission); err != nil {
+		t.Fatal(err)
+	}
+	return submission, Queue.Submit(context.Background(), submission, testIOProblem, false)
+}
+
+func awaitOutcome(t *testing.T, done <-chan JudgeOutcome) JudgeOutcome {
+	t.Helper()
+	select {
+	case outcome := <-done:
+		return outcome
+	case <-time.After(10 * time.Second):
+		t.Fatal("submission never finished")
+		return JudgeOutcome{}
+	}
+}
+
+func TestCancelSubmission(t *testing.T) {
+	stores, executor := startTestQueue(t)
+	running, runningDone := submitTest(t, stores)
+	queued, queuedDone := submitTest(t, stores)
+	<-executor.started
+
+	for _, submission := range []Submission{queued, running} {
+		if !Queue.Cancel(submission.ID) {
+			t.Errorf("submission %d could not be cancelled", submission.ID)
+		}
+	}
+	for _, done := range []<-chan JudgeOutcome{runningDone, queuedDone} {
+		outcome := awaitOutcome(t, done)
+		if outcome.Err != nil || outcome.Verdict.Status != StatusCanceled {
+			t.Errorf("submission %d: %+v, %v; want Canceled", outcome.Submission.ID, outcome.Verdict, outcome.Err)
+		}
+		stored, err := stores.Submissions.GetSubmissionByID(outcome.Submission.ID)
+		if err != nil || stored.Status != StatusCanceled {
+			t.Errorf("stored submission %+v, %v; want Canceled", stored, err)
+		}
+	}
+	if Queue.Pending(running.ID) || Queue.Cancel(running.ID) {
+		t.Error("a cancelled submission is still pending")
+	}
+}
+
+// Shutdown stops what is running and leaves nothing to submit to
+func TestShutdownRefusesSubmissions(t *testing.T) {
+	stores, executor := startTestQueue(t)
+	_, done := submitTest(t, stores)
+	<-executor.started
+
+	Queue.Shutdown()
+	select {
+	case outcome := <-done:
+		if outcome.Verdict.Status != StatusCanceled {
+			t.Errorf("running submission %+v after shutdown, want Canceled", outcome.Verdict)
+		}
+	default:
+		t.Fatal("shutdown returned before the running submission finished")
+	}
+
+	late, done := submitTest(t, stores)
+	if outcome := awaitOutcome(t, done); !errors.Is(outcome.Err, ErrQueueClosed) {
+		t.Errorf("submission after shutdown: %+v, %v; want ErrQueueClosed", outcome.Verdict, outcome.Err)
+	}
+	if Queue.Pending(late.ID) {
+		t.Error("submission after shutdown is pending")
+	}
+	stored, err := stores.Submissions.GetSubmissionByID(late.ID)
+	if err != nil || stored.Status != StatusQueued {
+		t.Errorf("submission after shutdown stored as %+v, %v; want it left alone", stored, err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// User roles. Contestants can only submit; setters write problems; contest
+// managers create contests and run the ones they are staff on; admins can
+// do anything.
+const (
+	RoleAdmin          = "admin"
+	RoleSetter         = "setter"
+	RoleContestManager = "contest_manager"
+	RoleContestant     = "contestant"
+)
+
+var Roles = []string{RoleAdmin, RoleSetter, RoleContestManager, RoleContestant}
+
+// RequireRole rejects users without one of the given roles. Admins always
+// pass. It must run after AuthRequired.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		if user.Role != RoleAdmin && !slices.Contains(roles, user.Role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// canManageContest reports whether user may edit a contest, its problems
+// and see its registrations
+func (s *Server) canManageContest(user User, contestID uint) bool {
+	if user.Role == RoleAdmin {
+		return true
+	}
+	if contestID == 0 {
+		return false
+	}
+	staff, err := s.Contests.IsContestStaff(contestID, user.Username)
+	return err == nil && staff
+}
+
+// canEditProblem reports whether user may edit a problem: its owner, staff
+// of its contest or an admin
+func (s *Server) canEditProblem(user User, problem Problem) bool {
+	if user.Role == RoleAdmin {
+		return true
+	}
+	if problem.OwnerID != "" && problem.OwnerID == user.Username {
+		return true
+	}
+	return s.canManageContest(user, problem.ContestID)
+}
+
+// EnsureAdmin creates the admin account named by ADMIN_USERNAME and
+// ADMIN_PASSWORD, or promotes it if it already exists, so a fresh install
+// has someone who can hand out roles
+func (s *Server) EnsureAdmin() {
+	username := os.Getenv("ADMIN_USERNAME")
+	password := os.Getenv("ADMIN_PASSWORD")
+	if username == "" || password == "" {
+		return
+	}
+	exists, err := s.Users.UserExists(username)
+	if err != nil {
+		panic("error: " + err.Error())
+	}
+	if exists {
+		if err := s.Users.SetUserRole(username, RoleAdmin); err != nil {
+			panic("error: " + err.Error())
+		}
+		return
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		panic("error: " + err.Error())
+	}
+	if err := s.Users.CreateUser(User{Username: username, Password: hash, Role: RoleAdmin}); err != nil {
+		panic("error: " + err.Error())
+	}
+}
+
+func (s *Server) handleSetUserRole(c *gin.Context) {
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !slices.Contains(Roles, body.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role", "roles": Roles})
+		return
+	}
+	username := c.Param("username")
+	user, err := s.Users.GetUser(username)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err := s.Users.SetUserRole(username, body.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.audit(c, "user.role", "user", username, gin.H{"role": user.Role}, gin.H{"role": body.Role})
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated successfully"})
+}
+
+func (s *Server) handleGetContestStaff(c *gin.Context) {
+	contestID, ok := s.managedContestID(c)
+	if !ok {
+		return
+	}
+	staff, err := s.Contests.GetContestStaff(contestID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, staff)
+}
+
+func (s *Server) handleAddContestStaff(c *gin.Context) {
+	contestID, ok := s.managedContestID(c)
+	if !ok {
+		return
+	}
+	var body struct {
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	exists, err := s.Users.UserExists(body.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err := s.Contests.AddContestStaff(contestID, body.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.audit(c, "contest.staff.add", "contest", contestID, nil, gin.H{"staff": body.Username})
+	c.JSON(http.StatusOK, gin.H{"message": "Staff added successfully"})
+}
+
+func (s *Server) handleRemoveContestStaff(c *gin.Context) {
+	contestID, ok := s.managedContestID(c)
+	if !ok {
+		return
+	}
+	if err := s.Contests.RemoveContestStaff(contestID, c.Param("username")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.audit(c, "contest.staff.remove", "contest", contestID, gin.H{"staff": c.Param("username")}, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Staff removed successfully"})
+}
+
+// managedContestID parses the :id parameter and checks the current user
+// manages that contest, writing the error response if not
+func (s *Server) managedContestID(c *gin.Context) (uint, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contest ID"})
+		return 0, false
+	}
+	if !s.canManageContest(currentUser(c), uint(id)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return 0, false
+	}
+	return uint(id), true
+}
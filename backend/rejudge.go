@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// RejudgeChange records a submission whose verdict changed on rejudge
+type RejudgeChange struct {
+	SubmissionID uint   `json:"submission_id"`
+	UserID       string `json:"user_id"`
+	ProblemID    uint   `json:"problem_id"`
+	OldStatus    string `json:"old_status"`
+	NewStatus    string `json:"new_status"`
+}
+
+// RejudgeReport summarises a rejudge run
+type RejudgeReport struct {
+	Rejudged int             `json:"rejudged"`
+	Skipped  int             `json:"skipped"` // Submissions recorded before source code was stored
+	Failed   int             `json:"failed"`  // Submissions the judge could not run or that were cancelled; verdict kept
+	Changes  []RejudgeChange `json:"changes"`
+}
+
+// Rejudge re-runs the stored source of every submission matching the filter
+// against the current test data, updates their verdicts and rebroadcasts the
+// leaderboard of every contest whose results changed
+func (s *Server) Rejudge(ctx context.Context, filter SubmissionFilter) (RejudgeReport, error) {
+	report := RejudgeReport{Changes: []RejudgeChange{}}
+
+	submissions, err := s.Submissions.FindSubmissions(filter)
+	if err != nil {
+		return report, err
+	}
+
+	problems := make(map[uint]Problem)
+	var pending []<-chan JudgeOutcome
+	var previous []Submission
+	for _, submission := range submissions {
+		if submission.Source == "" {
+			report.Skipped++
+			continue
+		}
+
+		problem, ok := problems[submission.ProblemID]
+		if !ok {
+			problem, err = s.Problems.GetProblemByID(submission.ProblemID)
+			if err != nil {
+				return report, fmt.Errorf("problem %d: %v", submission.ProblemID, err)
+			}
+			problems[submission.ProblemID] = problem
+		}
+
+		pending = append(pending, Queue.Submit(ctx, submission, problem, true))
+		previous = append(previous, submission)
+	}
+
+	affectedContests := make(map[uint]bool)
+	for i, done := range pending {
+		outcome := <-done
+		old := previous[i]
+		if outcome.Verdict.Status == StatusError || outcome.Verdict.Status == StatusCanceled {
+			report.Failed++
+			continue
+		}
+		report.Rejudged++
+
+		if outcome.Submission.Status != old.Status {
+			report.Changes = append(report.Changes, RejudgeChange{
+				SubmissionID: old.ID,
+				UserID:       old.UserID,
+				ProblemID:    old.ProblemID,
+				OldStatus:    old.Status,
+				NewStatus:    outcome.Submission.Status,
+			})
+			if contestID := problems[old.ProblemID].ContestID; contestID != 0 {
+				affectedContests[contestID] = true
+			}
+		}
+	}
+
+	contestIDs := make([]uint, 0, len(affectedContests))
+	for contestID := range affectedContests {
+		contestIDs = append(contestIDs, contestID)
+	}
+	sort.Slice(contestIDs, func(i, j int) bool { return contestIDs[i] < contestIDs[j] })
+	for _, contestID := range contestIDs {
+		leaderboard, err := s.Submissions.GetContestLeaderboard(contestID)
+		if err != nil {
+			return report, err
+		}
+		Broker.Broadcast(contestID, leaderboard)
+	}
+
+	return report, nil
+}
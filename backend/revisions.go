@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// editableProblem loads the problem named by the :id parameter and checks
+// the current user may edit it, answering the request if not
+func (s *Server) editableProblem(c *gin.Context) (Problem, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid problem ID"})
+		return Problem{}, false
+	}
+	problem, err := s.Problems.GetProblemByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Problem not found"})
+		return Problem{}, false
+	}
+	if !s.canEditProblem(currentUser(c), problem) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return Problem{}, false
+	}
+	return problem, true
+}
+
+// problemRevision loads a revision of problem, answering the request if
+// there is no such revision
+func (s *Server) problemRevision(c *gin.Context, problem Problem, value string) (Problem, bool) {
+	revision, err := strconv.Atoi(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+		return Problem{}, false
+	}
+	if revision == problem.Revision {
+		return problem, true
+	}
+	old, err := s.Problems.GetProblemRevision(problem.ID, revision)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return Problem{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return Problem{}, false
+	}
+	return old, true
+}
+
+func (s *Server) handleGetProblemRevisions(c *gin.Context) {
+	problem, ok := s.editableProblem(c)
+	if !ok {
+		return
+	}
+	revisions, err := s.Problems.GetProblemRevisions(problem.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, revisions)
+}
+
+// handleGetProblemRevision returns a problem as it was at a revision, test
+// data included
+func (s *Server) handleGetProblemRevision(c *gin.Context) {
+	problem, ok := s.editableProblem(c)
+	if !ok {
+		return
+	}
+	revision, ok := s.problemRevision(c, problem, c.Param("rev"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, revision)
+}
+
+// handleDiffProblemRevisions compares revision :rev with revision "to", by
+// default the current one. Changes are in the format of the audit log, with
+// large test data shown by hash.
+func (s *Server) handleDiffProblemRevisions(c *gin.Context) {
+	problem, ok := s.editableProblem(c)
+	if !ok {
+		return
+	}
+	from, ok := s.problemRevision(c, problem, c.Param("rev"))
+	if !ok {
+		return
+	}
+	to, ok := s.problemRevision(c, problem, c.DefaultQuery("to", strconv.Itoa(problem.Revision)))
+	if !ok {
+		return
+	}
+	changes, err := auditDiff(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"from": from.Revision, "to": to.Revision, "changes": changes})
+}
+
+// handleRollbackProblem makes an old revision current again. The rollback
+// is itself a new revision, so it can be undone the same way.
+func (s *Server) handleRollbackProblem(c *gin.Context) {
+	problem, ok := s.editableProblem(c)
+	if !ok {
+		return
+	}
+	revision, err := strconv.Atoi(c.Param("rev"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+		return
+	}
+	err = s.Problems.RollbackProblem(problem.ID, revision, currentUser(c).Username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.auditProblemChange(c, "problem.rollback", problem)
+
+	current, err := s.Problems.GetProblemByID(problem.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Problem rolled back to revision " + strconv.Itoa(revision), "revision": current.Revision})
+}
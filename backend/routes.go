@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,9 +18,10 @@ import (
 // usermanagement, read and write to sqllite using gorm
 
 type Run struct {
-	Username string `json:"username"`
 	Problem  string `json:"problem"`
 	Solution string `json:"solution"`
+	Language string `json:"language"` // Defaults to python
+	Async    bool   `json:"async"`    // Return at once; follow GET /submission/:id/events
 }
 
 func sayHello(c *gin.Context) {
@@ -26,12 +31,13 @@ func sayHello(c *gin.Context) {
 	})
 }
 
-func handleRun(c *gin.Context) {
+func (s *Server) handleRun(c *gin.Context) {
 	var run Run
 	if err := c.ShouldBindJSON(&run); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	user := currentUser(c)
 
 	problemID, err := strconv.Atoi(run.Problem)
 	if err != nil {
@@ -39,172 +45,322 @@ func handleRun(c *gin.Context) {
 		return
 	}
 
-	problem, err := GetProblemByID(uint(problemID))
+	problem, err := s.Problems.GetProblemByID(uint(problemID))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Problem not found"})
 		return
 	}
 
-	// === NEW: Function-Based Execution ===
-	if problem.SignatureJSON != "" {
-		var signature ProblemSignature
-		if err := json.Unmarshal([]byte(problem.SignatureJSON), &signature); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid problem signature"})
-			return
-		}
-		
-		var testCases []map[string]interface{}
-		if err := json.Unmarshal([]byte(problem.TestCasesJSON), &testCases); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid test cases"})
-			return
-		}
-
-		results, err := ExecuteFunctionRun(run.Username, run.Solution, signature, testCases)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		// Validation & Scoring
-		status := "Passed"
-		var firstFailedResult *RunResult
-		var firstFailedInput interface{}
-		var firstFailedExpected interface{}
-		failedIndex := -1
-		passedCount := 0
-		totalCount := len(testCases)
-
-		for i, res := range results {
-			expected := testCases[i]["output"]
-			
-			resBytes, _ := json.Marshal(res.Result)
-			expBytes, _ := json.Marshal(expected)
-			
-			passed := res.Status == "ok" && string(resBytes) == string(expBytes)
-
-			if passed {
-				passedCount++
-			} else if firstFailedResult == nil {
-				status = "Failed"
-				firstFailedResult = &results[i]
-				firstFailedInput = testCases[i]["input"]
-				firstFailedExpected = expected
-				failedIndex = i + 1 // 1-based index
-			}
-		}
+	if run.Language == "" {
+		run.Language = "python"
+	}
+	lang, err := GetLanguage(run.Language)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if problem.SignatureJSON != "" && !lang.SupportsFunctions() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": lang.Name + " can't be used for function-based problems"})
+		return
+	}
 
-		if status == "Passed" {
-			CreateSubmission(Submission{
-				UserID:    run.Username,
-				ProblemID: uint(problemID),
-				Status:    "Passed",
-				CreatedAt: time.Now(),
-			})
-			if problem.ContestID != 0 {
-				leaderboard, _ := GetContestLeaderboard(problem.ContestID)
-				Broker.Broadcast(problem.ContestID, leaderboard)
-			}
-		}
+	submission := Submission{
+		UserID:    user.Username,
+		ProblemID: problem.ID,
+		Status:    StatusQueued,
+		CreatedAt: time.Now(),
+		Language:  lang.ID,
+		Source:    run.Solution,
 
-		// Format response
-		var output, expectedStr, inputStr string
-		if firstFailedResult != nil {
-			outputBytes, _ := json.Marshal(firstFailedResult.Result)
-			if firstFailedResult.Status != "ok" {
-				output = firstFailedResult.Error // Show error if runtime error
-			} else {
-				output = string(outputBytes)
-			}
-			
-			expBytes, _ := json.Marshal(firstFailedExpected)
-			expectedStr = string(expBytes)
-			
-			inBytes, _ := json.Marshal(firstFailedInput)
-			inputStr = string(inBytes)
-		}
+		ProblemRevision: problem.Revision,
+	}
+	if err := s.Submissions.CreateSubmission(&submission); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
+	if run.Async {
+		// The judge outlives this request, so it can't be tied to its context
+		done := Queue.Submit(context.Background(), submission, problem, false)
+		go func() {
+			outcome := <-done
+			s.announceVerdict(problem, outcome.Verdict)
+		}()
 		c.JSON(http.StatusAccepted, gin.H{
-			"username":        run.Username,
-			"message":         "", 
-			"output":          output,
-			"status":          status,
-			"expected_output": expectedStr,
-			"actual_output":   output,
-			"test_case_input": inputStr,
-			"passed_count":    passedCount,
-			"total_count":     totalCount,
-			"failed_index":    failedIndex,
+			"username":      user.Username,
+			"submission_id": submission.ID,
+			"status":        StatusQueued,
+		})
+		return
+	}
+
+	outcome := <-Queue.Submit(c.Request.Context(), submission, problem, false)
+	verdict := outcome.Verdict
+	if outcome.Err != nil {
+		// Include the captured output so user can see the Python traceback
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":         outcome.Err.Error(),
+			"output":        verdict.Output,
+			"status":        verdict.Status,
+			"submission_id": submission.ID,
 		})
 		return
 	}
 
-	// === LEGACY: IO-Based Execution ===
-	// problem is now the ID string
-	err = hydrateRunDirectory(run.Username, run.Problem, run.Solution)
+	s.announceVerdict(problem, verdict)
+
+	response := gin.H{
+		"username":        user.Username,
+		"submission_id":   submission.ID,
+		"message":         verdict.Message,
+		"output":          verdict.Output,
+		"status":          verdict.Status,
+		"expected_output": verdict.ExpectedOutput,
+		"actual_output":   verdict.ActualOutput,
+		"test_case_input": verdict.TestCaseInput,
+	}
+	if problem.SignatureJSON != "" || verdict.TotalCount > 1 {
+		response["passed_count"] = verdict.PassedCount
+		response["total_count"] = verdict.TotalCount
+		response["failed_index"] = verdict.FailedIndex
+	}
+	c.JSON(http.StatusAccepted, response)
+}
+
+type ExecuteRequest struct {
+	Language string `json:"language"` // Defaults to python
+	Source   string `json:"source" binding:"required"`
+	Stdin    string `json:"stdin"`
+}
+
+// handleExecute runs code against user-supplied stdin. It isn't tied to a
+// problem and records no submission.
+func handleExecute(c *gin.Context) {
+	// Refuse oversized stdin before reading it all into memory
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxExecuteBody)
+	var req ExecuteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Language == "" {
+		req.Language = "python"
+	}
+	lang, err := GetLanguage(req.Language)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	defer cleanRunDirectory(run.Username)
+	if len(req.Stdin) > MaxExecuteStdin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "stdin is too large"})
+		return
+	}
 
-	message, err := runInContainer(run.Username)
+	result, err := ExecuteScratch(c.Request.Context(), lang, req.Source, req.Stdin)
 	if err != nil {
-		// Include the stderr (message) so user can see the Python traceback
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":  err.Error(),
-			"output": message,
-		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, result)
+}
 
-	output, err := getOutputText(run.Username)
+// announceVerdict broadcasts the contest leaderboard if a submission passed
+func (s *Server) announceVerdict(problem Problem, verdict Verdict) {
+	if verdict.Status == StatusPassed && problem.ContestID != 0 {
+		leaderboard, _ := s.Submissions.GetContestLeaderboard(problem.ContestID)
+		Broker.Broadcast(problem.ContestID, leaderboard)
+	}
+}
+
+func (s *Server) handleGetSubmission(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid submission ID"})
 		return
 	}
+	submission, err := s.Submissions.GetSubmissionByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Submission not found"})
+		return
+	}
+	submission.Source = ""
+	c.JSON(http.StatusOK, submission)
+}
 
-	passed, expected, actual, input, err := checkOutput(run.Username)
-	status := "Failed"
-	if err == nil && passed {
-		status = "Passed"
-		// Record submission
-		// problemID, _ := strconv.Atoi(run.Problem) // run.Problem is ID string
-		CreateSubmission(Submission{
-			UserID:    run.Username,
-			ProblemID: uint(problemID),
-			Status:    "Passed",
-			CreatedAt: time.Now(),
-		})
+// handleSubmissionStream streams the judging events of a submission. A
+// submission that has already been judged gets a single "finished" event.
+func (s *Server) handleSubmissionStream(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid submission ID"})
+		return
+	}
+
+	// Subscribe before reading the status so the "finished" event can't
+	// slip in between
+	clientChan := SubmissionEvents.Subscribe(uint(id))
+	defer SubmissionEvents.Unsubscribe(uint(id), clientChan)
+
+	submission, err := s.Submissions.GetSubmissionByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Submission not found"})
+		return
+	}
 
-		// Broadcast Leaderboard Update
-		problem, _ := GetProblemByID(uint(problemID))
-		if problem.ContestID != 0 {
-			leaderboard, _ := GetContestLeaderboard(problem.ContestID)
-			Broker.Broadcast(problem.ContestID, leaderboard)
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Transfer-Encoding", "chunked")
+
+	if event, done := finishedEvent(submission); done {
+		c.SSEvent("message", event)
+		return
+	}
+
+	// The broker disconnects clients that fall behind, and a restart loses
+	// the judge's events altogether, so the stored status is checked again
+	// every so often instead of trusting the events to arrive
+	events := clientChan
+	ticker := time.NewTicker(SubmissionStreamPoll)
+	defer ticker.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case msg, ok := <-events:
+			if !ok {
+				events = nil
+				return true
+			}
+			c.SSEvent("message", msg)
+			var event JudgeEvent
+			json.Unmarshal([]byte(msg), &event)
+			return event.Event != EventFinished
+		case <-ticker.C:
+			submission, err := s.Submissions.GetSubmissionByID(uint(id))
+			if err != nil {
+				return false
+			}
+			if event, done := finishedEvent(submission); done {
+				c.SSEvent("message", event)
+				return false
+			}
+			// Keeps proxies from closing an idle stream
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
 		}
+	})
+}
+
+// SubmissionStreamPoll is how often a submission stream re-reads the status
+var SubmissionStreamPoll = 15 * time.Second
+
+// finishedEvent returns the "finished" event of a submission that is no
+// longer being judged. One still Queued that the queue doesn't know about
+// was lost to a restart and is reported as Error; a rejudge judges it.
+func finishedEvent(submission Submission) (JudgeEvent, bool) {
+	status := submission.Status
+	if status == StatusQueued {
+		if Queue.Pending(submission.ID) {
+			return JudgeEvent{}, false
+		}
+		status = StatusError
 	}
+	return JudgeEvent{
+		Event:        EventFinished,
+		SubmissionID: submission.ID,
+		Total:        submission.TotalCount,
+		Passed:       submission.PassedCount,
+		Status:       status,
+	}, true
+}
 
-	c.JSON(http.StatusAccepted, gin.H{
-		"username":        run.Username,
-		"message":         message,
-		"output":          output,
-		"status":          status,
-		"expected_output": expected,
-		"actual_output":   actual,
-		"test_case_input": input,
+func handleGetLanguages(c *gin.Context) {
+	languages := make([]PublicLanguage, len(Languages))
+	for i, lang := range Languages {
+		languages[i] = lang.Public()
+	}
+	c.JSON(http.StatusOK, languages)
+}
+
+// handleCancelSubmission stops a queued or running submission. Only the
+// user who submitted it or an admin may cancel it.
+func (s *Server) handleCancelSubmission(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid submission ID"})
+		return
+	}
+
+	submission, err := s.Submissions.GetSubmissionByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Submission not found"})
+		return
+	}
+	if user := currentUser(c); submission.UserID != user.Username && user.Role != RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not your submission"})
+		return
+	}
+	if !Queue.Cancel(submission.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Submission is not being judged", "status": submission.Status})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Submission cancelled"})
+}
+
+func (s *Server) handleRejudge(c *gin.Context) {
+	var filter SubmissionFilter
+	if err := c.ShouldBindJSON(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter == (SubmissionFilter{}) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Specify a submission_id, problem_id, contest_id or user_id"})
+		return
+	}
+
+	report, err := s.Rejudge(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.audit(c, "submission.rejudge", "submission", "", nil, gin.H{
+		"filter":   filter,
+		"rejudged": report.Rejudged,
+		"failed":   report.Failed,
+		"changes":  report.Changes,
 	})
+	c.JSON(http.StatusOK, report)
 }
 
-func handleGetLeaderboard(c *gin.Context) {
-	leaderboard, err := GetLeaderboard()
+// handleGetLeaderboard ranks users by their points over all problems, a
+// page at a time, see parseListOptions
+func (s *Server) handleGetLeaderboard(c *gin.Context) {
+	opts, ok := parseListOptions(c, leaderboardOrder, "-score")
+	if !ok {
+		return
+	}
+	leaderboard, page, err := s.Submissions.GetLeaderboard(opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if leaderboard == nil {
+		leaderboard = []LeaderboardEntry{}
+	}
+	setPageHeaders(c, page)
 	c.JSON(http.StatusOK, leaderboard)
 }
 
-func handleGetContestLeaderboard(c *gin.Context) {
+func (s *Server) handleGetContestLeaderboard(c *gin.Context) {
 	contestIDStr := c.Param("id")
 	contestID, err := strconv.Atoi(contestIDStr)
 	if err != nil {
@@ -212,7 +368,7 @@ func handleGetContestLeaderboard(c *gin.Context) {
 		return
 	}
 
-	leaderboard, err := GetContestLeaderboard(uint(contestID))
+	leaderboard, err := s.Submissions.GetContestLeaderboard(uint(contestID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -250,7 +406,7 @@ func handleLeaderboardStream(c *gin.Context) {
 	})
 }
 
-func handleGetProblemLeaderboard(c *gin.Context) {
+func (s *Server) handleGetProblemLeaderboard(c *gin.Context) {
 	problemIDStr := c.Param("id")
 	problemID, err := strconv.Atoi(problemIDStr)
 	if err != nil {
@@ -258,7 +414,7 @@ func handleGetProblemLeaderboard(c *gin.Context) {
 		return
 	}
 
-	problem, err := GetProblemByID(uint(problemID))
+	problem, err := s.Problems.GetProblemByID(uint(problemID))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Problem not found"})
 		return
@@ -266,7 +422,7 @@ func handleGetProblemLeaderboard(c *gin.Context) {
 
 	if problem.ContestID != 0 {
 		// Return the contest leaderboard
-		leaderboard, err := GetContestLeaderboard(problem.ContestID)
+		leaderboard, err := s.Submissions.GetContestLeaderboard(problem.ContestID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -275,7 +431,7 @@ func handleGetProblemLeaderboard(c *gin.Context) {
 	} else {
 		// Fallback for practice problems (global leaderboard or specific)
 		// For now, let's keep the old behavior for practice problems or return empty
-		leaderboard, err := GetProblemLeaderboard(uint(problemID))
+		leaderboard, err := s.Submissions.GetProblemLeaderboard(uint(problemID))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -284,45 +440,47 @@ func handleGetProblemLeaderboard(c *gin.Context) {
 	}
 }
 
-func handleCreateUser(c *gin.Context) {
-	var user User
-	if err := c.ShouldBindJSON(&user); err != nil {
+func (s *Server) handleCreateUser(c *gin.Context) {
+	// User.Password is never read from or written to JSON, so bind the
+	// plaintext separately
+	var body struct {
+		Username string
+		Email    string
+		Password string
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hash, err := HashPassword(body.Password)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := CreateUser(user); err != nil {
-		errMsg := err.Error()
-		if strings.Contains(errMsg, "UNIQUE constraint failed") {
+	user := User{Username: body.Username, Email: body.Email, Password: hash}
+	if err := s.Users.CreateUser(user); err != nil {
+		if errors.Is(err, ErrUserExists) {
 			c.JSON(http.StatusConflict, gin.H{"error": "Identity already claimed (Username taken)"})
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": errMsg})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{"message": "User created successfully"})
 }
 
-func handleUserExists(c *gin.Context) {
+// handleUserExists checks whether a username is taken, e.g. during
+// registration. Logging in goes through POST /login.
+func (s *Server) handleUserExists(c *gin.Context) {
 	var body struct {
 		Username string `json:"username"`
-		Password string `json:"password"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var exists bool
-	var err error
-
-	if body.Password == "" {
-		// Just checking if identity exists (e.g., during registration)
-		exists, err = UserExists(body.Username)
-	} else {
-		// Verifying credentials (e.g., during login)
-		exists, err = VerifyUser(body.Username, body.Password)
-	}
-
+	exists, err := s.Users.UserExists(body.Username)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -331,56 +489,108 @@ func handleUserExists(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"exists": exists})
 }
 
-func handleCreateContest(c *gin.Context) {
+func (s *Server) handleCreateContest(c *gin.Context) {
 	var contest Contest
 	if err := c.ShouldBindJSON(&contest); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := CreateContest(contest); err != nil {
+	contest.Problems = nil
+	if err := s.Contests.CreateContest(&contest); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// The creator runs the contest until more staff are added
+	if err := s.Contests.AddContestStaff(contest.ID, currentUser(c).Username); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	s.audit(c, "contest.create", "contest", contest.ID, nil, contest)
 	c.JSON(http.StatusCreated, gin.H{"message": "Contest created successfully", "id": contest.ID})
 }
 
-func handleUpdateContest(c *gin.Context) {
+func (s *Server) handleUpdateContest(c *gin.Context) {
 	var contest Contest
 	if err := c.ShouldBindJSON(&contest); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := UpdateContest(contest); err != nil {
+	if !s.canManageContest(currentUser(c), contest.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	before, err := s.Contests.GetContestByID(contest.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contest not found"})
+		return
+	}
+	// Problems are moved into a contest with PUT /problem
+	before.Problems, contest.Problems = nil, nil
+	if err := s.Contests.UpdateContest(contest); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	s.audit(c, "contest.update", "contest", contest.ID, before, contest)
 	c.JSON(http.StatusOK, gin.H{"message": "Contest updated successfully"})
 }
 
-func handleDeleteContest(c *gin.Context) {
+func (s *Server) handleDeleteContest(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contest ID"})
 		return
 	}
-	if err := DeleteContest(uint(id)); err != nil {
+	if !s.canManageContest(currentUser(c), uint(id)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	before, err := s.Contests.GetContestByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contest not found"})
+		return
+	}
+	if err := s.Contests.DeleteContest(uint(id)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	before.Problems = nil
+	s.audit(c, "contest.delete", "contest", before.ID, before, nil)
 	c.JSON(http.StatusOK, gin.H{"message": "Contest deleted successfully"})
 }
 
-func handleGetContests(c *gin.Context) {
-	contests, err := GetContests()
+// handleGetContests lists contests a page at a time, see parseListOptions.
+// status=upcoming, active or ended filters them.
+func (s *Server) handleGetContests(c *gin.Context) {
+	opts, ok := parseListOptions(c, contestOrder, "id")
+	if !ok {
+		return
+	}
+	filter := ContestFilter{Status: c.Query("status")}
+	switch filter.Status {
+	case "", "upcoming", "active", "ended":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be upcoming, active or ended"})
+		return
+	}
+	contests, page, err := s.Contests.ListContests(filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ids := make([]uint, len(contests))
+	for i, contest := range contests {
+		ids[i] = contest.ID
+	}
+	counts, err := s.Registrations.CountContestRegistrations(ids)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	var result []map[string]interface{}
+	result := []map[string]interface{}{}
 	for _, contest := range contests {
-		count, _ := GetContestRegistrationsCount(contest.ID)
+		count := counts[contest.ID]
 		result = append(result, map[string]interface{}{
 			"id":           contest.ID,
 			"title":        contest.Title,
@@ -392,36 +602,81 @@ func handleGetContests(c *gin.Context) {
 		})
 	}
 
+	setPageHeaders(c, page)
 	c.JSON(http.StatusOK, result)
 }
 
-func handleCreateProblem(c *gin.Context) {
+func (s *Server) handleCreateProblem(c *gin.Context) {
 	var problem Problem
 	if err := c.ShouldBindJSON(&problem); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := CreateProblem(problem); err != nil {
+	user := currentUser(c)
+	// Only the contest's staff may add problems to it
+	if problem.ContestID != 0 && !s.canManageContest(user, problem.ContestID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	problem.OwnerID = user.Username
+	problem.Tags = normalizeTags(problem.Tags)
+	if !checkProblem(c, problem) {
+		return
+	}
+	if err := s.Problems.CreateProblem(&problem, user.Username); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	s.audit(c, "problem.create", "problem", problem.ID, nil, problem)
 	c.JSON(http.StatusCreated, gin.H{"message": "Problem created successfully", "id": problem.ID})
 }
 
-func handleGetContest(c *gin.Context) {
+// checkProblem validates the problem's signature and test cases, then runs
+// its input validator, and writes an error response if anything is rejected.
+// Returns true if the problem is fine.
+func checkProblem(c *gin.Context, problem Problem) bool {
+	if errs := ValidateProblemSchema(problem); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid problem definition", "errors": errs})
+		return false
+	}
+	if err := ValidateTestInputs(c.Request.Context(), problem); err != nil {
+		if _, ok := err.(*TestDataError); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return false
+	}
+	return true
+}
+
+func handleValidateProblem(c *gin.Context) {
+	var problem Problem
+	if err := c.ShouldBindJSON(&problem); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	errs := ValidateProblemSchema(problem)
+	if errs == nil {
+		errs = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": len(errs) == 0, "errors": errs})
+}
+
+func (s *Server) handleGetContest(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contest ID"})
 		return
 	}
-	contest, err := GetContestByID(uint(id))
+	contest, err := s.Contests.GetContestByID(uint(id))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Contest not found"})
 		return
 	}
 
-	count, _ := GetContestRegistrationsCount(contest.ID)
+	count, _ := s.Registrations.GetContestRegistrationsCount(contest.ID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"id":                  contest.ID,
@@ -435,103 +690,349 @@ func handleGetContest(c *gin.Context) {
 	})
 }
 
-func handleGetProblem(c *gin.Context) {
+func (s *Server) handleGetProblem(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid problem ID"})
 		return
 	}
-	problem, err := GetProblemByID(uint(id))
+	problem, err := s.Problems.GetProblemByID(uint(id))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Problem not found"})
 		return
 	}
 
-	// Hide hidden runner code from public API
-	problem.RunnerCode = ""
+	// Hide hidden runner code and the tests from public API; only those who
+	// may edit the problem see them
+	if user, ok := s.sessionUser(c); !ok || !s.canEditProblem(user, problem) {
+		problem.RunnerCode = ""
+		problem.Validator = ""
+		problem.Input, problem.Output, problem.TestCasesJSON = "", "", ""
+	}
 
 	c.JSON(http.StatusOK, problem)
 }
 
-func handleGetPracticeProblems(c *gin.Context) {
-	problems, err := GetPracticeProblems()
+func (s *Server) handleGetProblemTemplate(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid problem ID"})
+		return
+	}
+	problem, err := s.Problems.GetProblemByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Problem not found"})
+		return
+	}
+
+	lang := c.DefaultQuery("lang", "python")
+	template, err := ProblemTemplate(problem, lang)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "languages": TemplateLanguages()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"language": lang, "template": template})
+}
+
+func (s *Server) handleGetProblemPlagiarism(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid problem ID"})
+		return
+	}
+	threshold, err := strconv.ParseFloat(c.DefaultQuery("threshold", "0.5"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid threshold"})
+		return
+	}
+
+	problem, err := s.Problems.GetProblemByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Problem not found"})
+		return
+	}
+	if !s.canEditProblem(currentUser(c), problem) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	submissions, err := s.Submissions.GetAcceptedSubmissions(problem.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, problems)
+
+	// Starter code every contestant was given must not count as copying
+	templates := []string{problem.Template}
+	for _, lang := range TemplateLanguages() {
+		if template, err := ProblemTemplate(problem, lang); err == nil {
+			templates = append(templates, template)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"problem_id":  problem.ID,
+		"submissions": len(submissions),
+		"pairs":       DetectPlagiarism(submissions, templates, threshold),
+	})
 }
 
-func handleGetAllProblems(c *gin.Context) {
-	problems, err := GetAllProblems()
+// handleGetAllProblems lists problems a page at a time, see
+// parseListOptions. contest_id (0 for practice problems) and the filters
+// of parseProblemFilter select them.
+func (s *Server) handleGetAllProblems(c *gin.Context) {
+	opts, ok := parseListOptions(c, problemOrder, "id")
+	if !ok {
+		return
+	}
+	var filter ProblemFilter
+	if !s.parseProblemFilter(c, &filter) {
+		return
+	}
+	if value := c.Query("contest_id"); value != "" {
+		contestID, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contest ID"})
+			return
+		}
+		filter.ContestID = uint(contestID)
+		filter.Practice = contestID == 0
+	}
+	problems, page, err := s.Problems.ListProblems(filter, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if problems == nil {
+		problems = []Problem{}
+	}
+	setPageHeaders(c, page)
 	c.JSON(http.StatusOK, problems)
 }
 
-func handleGetAllUsers(c *gin.Context) {
-	users, err := GetAllUsers()
+// handleGetAllUsers lists users a page at a time with the contests they
+// registered for, see parseListOptions. role and q (part of the username or
+// email) filter them.
+func (s *Server) handleGetAllUsers(c *gin.Context) {
+	opts, ok := parseListOptions(c, userOrder, "username")
+	if !ok {
+		return
+	}
+	users, page, err := s.Users.ListUsers(UserFilter{Role: c.Query("role"), Query: c.Query("q")}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	usernames := make([]string, len(users))
+	for i, u := range users {
+		usernames[i] = u.Username
+	}
+	registered, err := s.Registrations.GetRegisteredContests(usernames)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	type UserResponse struct {
-		Username      string `json:"Username"`
-		Email         string `json:"Email"`
+		Username           string `json:"Username"`
+		Email              string `json:"Email"`
+		Role               string `json:"Role"`
 		RegisteredContests []uint `json:"registered_contests"`
 	}
 
-	var response []UserResponse
+	response := []UserResponse{}
 	for _, u := range users {
-		regs, _ := GetUserRegistrations(u.Username)
-		var contestIDs []uint
-		for _, r := range regs {
-			contestIDs = append(contestIDs, r.ContestID)
-		}
 		response = append(response, UserResponse{
 			Username:           u.Username,
 			Email:              u.Email,
-			RegisteredContests: contestIDs,
+			Role:               u.Role,
+			RegisteredContests: registered[u.Username],
 		})
 	}
 
+	setPageHeaders(c, page)
 	c.JSON(http.StatusOK, response)
 }
 
-func handleUpdateProblem(c *gin.Context) {
+func (s *Server) handleUpdateProblem(c *gin.Context) {
 	var problem Problem
 	if err := c.ShouldBindJSON(&problem); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := UpdateProblem(problem); err != nil {
+	existing, err := s.Problems.GetProblemByID(problem.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Problem not found"})
+		return
+	}
+	user := currentUser(c)
+	if !s.canEditProblem(user, existing) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	// Moving a problem into a contest needs the same rights as creating it there
+	if problem.ContestID != existing.ContestID && problem.ContestID != 0 && !s.canManageContest(user, problem.ContestID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	problem.OwnerID = existing.OwnerID
+	// Like tests, tags are kept unless the request carries some
+	if problem.Tags == nil {
+		problem.Tags = existing.Tags
+	}
+	problem.Tags = normalizeTags(problem.Tags)
+	// The store keeps the tests, but they are checked against the rest of
+	// the update all the same
+	checked := problem
+	if !hasTestData(problem) {
+		checked.Input, checked.Output, checked.TestCasesJSON = existing.Input, existing.Output, existing.TestCasesJSON
+	}
+	if !checkProblem(c, checked) {
+		return
+	}
+	if err := s.Problems.UpdateProblem(problem, user.Username); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	s.auditProblemChange(c, "problem.update", existing)
 	c.JSON(http.StatusOK, gin.H{"message": "Problem updated successfully"})
 }
 
-func handleDeleteProblem(c *gin.Context) {
+// handleUploadProblemTests replaces a problem's tests with the ones in an
+// uploaded zip, sent as the "file" field of a multipart form
+func (s *Server) handleUploadProblemTests(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid problem ID"})
 		return
 	}
-	if err := DeleteProblem(uint(id)); err != nil {
+	problem, err := s.Problems.GetProblemByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Problem not found"})
+		return
+	}
+	if !s.canEditProblem(currentUser(c), problem) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	before := problem
+
+	// Stop reading once the archive can't fit, whatever the form claims; the
+	// extra megabyte leaves room for the multipart framing
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxTestArchiveSize+1<<20)
+	header, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Test archive is too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload the test archive as the \"file\" field"})
+		return
+	}
+	if header.Size > MaxTestArchiveSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Test archive is too large"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	tests, err := ReadTestArchive(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := SetProblemTests(&problem, tests); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkProblem(c, problem) {
+		return
+	}
+	if err := s.Problems.UpdateProblem(problem, currentUser(c).Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.auditProblemChange(c, "problem.tests.upload", before)
+	c.JSON(http.StatusOK, gin.H{"message": "Tests uploaded successfully", "count": len(tests)})
+}
+
+func (s *Server) handleDownloadProblemTests(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid problem ID"})
+		return
+	}
+	problem, err := s.Problems.GetProblemByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Problem not found"})
+		return
+	}
+	if !s.canEditProblem(currentUser(c), problem) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	var archive bytes.Buffer
+	if err := WriteTestArchive(&archive, problem); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"problem-%d-tests.zip\"", problem.ID))
+	c.Data(http.StatusOK, "application/zip", archive.Bytes())
+}
+
+// auditProblemChange records an edit of a problem, reading it back so the
+// log shows the tests it ended up with
+func (s *Server) auditProblemChange(c *gin.Context, action string, before Problem) {
+	after, err := s.Problems.GetProblemByID(before.ID)
+	if err != nil {
+		log.Printf("audit: reading problem %d back: %v", before.ID, err)
+		return
+	}
+	s.audit(c, action, "problem", before.ID, before, after)
+}
+
+func (s *Server) handleDeleteProblem(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid problem ID"})
+		return
+	}
+	problem, err := s.Problems.GetProblemByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Problem not found"})
+		return
+	}
+	if !s.canEditProblem(currentUser(c), problem) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	if err := s.Problems.DeleteProblem(uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.audit(c, "problem.delete", "problem", problem.ID, problem, nil)
 	c.JSON(http.StatusOK, gin.H{"message": "Problem deleted successfully"})
 }
 
-func handleRegisterContest(c *gin.Context) {
+func (s *Server) handleRegisterContest(c *gin.Context) {
 	var body struct {
-		UserID    string `json:"user_id"`
 		ContestID uint   `json:"contest_id"`
 		ExtraInfo string `json:"extra_info"`
 	}
@@ -539,9 +1040,10 @@ func handleRegisterContest(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	userID := currentUser(c).Username
 
 	// Check if already registered
-	registered, err := IsUserRegistered(body.UserID, body.ContestID)
+	registered, err := s.Registrations.IsUserRegistered(userID, body.ContestID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -551,19 +1053,19 @@ func handleRegisterContest(c *gin.Context) {
 		return
 	}
 
-	if err := RegisterForContest(body.UserID, body.ContestID, body.ExtraInfo); err != nil {
+	if err := s.Registrations.RegisterForContest(userID, body.ContestID, body.ExtraInfo); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Registered successfully"})
 }
 
-func handleGetRegistrationStatus(c *gin.Context) {
-	userID := c.Query("user_id")
+func (s *Server) handleGetRegistrationStatus(c *gin.Context) {
+	userID := currentUser(c).Username
 	contestIDStr := c.Query("contest_id")
 	contestID, _ := strconv.Atoi(contestIDStr)
 
-	registered, err := IsUserRegistered(userID, uint(contestID))
+	registered, err := s.Registrations.IsUserRegistered(userID, uint(contestID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -572,18 +1074,30 @@ func handleGetRegistrationStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"registered": registered})
 }
 
-func handleGetContestRegistrations(c *gin.Context) {
+func (s *Server) handleGetContestRegistrations(c *gin.Context) {
 	contestIDStr := c.Param("id")
 	contestID, err := strconv.Atoi(contestIDStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contest ID"})
 		return
 	}
+	if !s.canManageContest(currentUser(c), uint(contestID)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
 
-	registrations, err := GetContestRegistrations(uint(contestID))
+	opts, ok := parseListOptions(c, registrationOrder, "id")
+	if !ok {
+		return
+	}
+	registrations, page, err := s.Registrations.ListContestRegistrations(uint(contestID), opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if registrations == nil {
+		registrations = []Registration{}
+	}
+	setPageHeaders(c, page)
 	c.JSON(http.StatusOK, registrations)
 }
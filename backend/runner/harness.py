@@ -3,18 +3,25 @@ import json
 import time
 import traceback
 
-# 1. Import User Code
-# The user's code is saved as 'solution.py' in the same directory.
-try:
-    from solution import Solution
-except ImportError:
-    print(json.dumps([{"status": "system_error", "error": "Could not import 'Solution' class. Ensure you have not changed the class name."}]))
-    sys.exit(0)
-except Exception as e:
-    print(json.dumps([{"status": "runtime_error", "error": f"Import Error: {str(e)}"}]))
-    sys.exit(0)
-
 def run():
+    # The Go backend passes the prefix of its progress markers on stdin. It
+    # is read before importing the user's code, so printing text can't forge
+    # a marker, and kept out of the module's globals. Code that inspects the
+    # harness's frames, whose process it shares, can still find it; markers
+    # only report progress, never the verdict.
+    marker = sys.stdin.readline().strip()
+
+    # 1. Import User Code
+    # The user's code is saved as 'solution.py' in the same directory.
+    try:
+        from solution import Solution
+    except ImportError:
+        print(json.dumps([{"status": "system_error", "error": "Could not import 'Solution' class. Ensure you have not changed the class name."}]))
+        sys.exit(0)
+    except Exception as e:
+        print(json.dumps([{"status": "runtime_error", "error": f"Import Error: {str(e)}"}]))
+        sys.exit(0)
+
     # 2. Load Test Cases
     # testcases.json contains a list of objects: [{"input": {...}, "output": ...}]
     try:
@@ -66,6 +73,10 @@ def run():
                 "traceback": traceback.format_exc()
             })
 
+        # Report progress to the Go backend, which keeps these lines out of stderr
+        if marker:
+            print(marker + "test " + json.dumps(results[-1]), file=sys.stderr, flush=True)
+
     # 5. Output Results as JSON
     # The Go backend will parse this line.
     print(json.dumps(results))
@@ -0,0 +1,690 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxInitArg is the hidden subcommand the server re-executes itself with
+// to set up the sandbox from inside the new namespaces
+const sandboxInitArg = "sandbox-init"
+
+const sandboxConfigEnv = "CODEJUDGE_SANDBOX"
+
+// supervisorFD is the socket sandbox-init sends the seccomp listener over
+// (the first of exec.Cmd.ExtraFiles)
+const supervisorFD = 3
+
+// sandboxConfig is handed from the server to sandbox-init
+type sandboxConfig struct {
+	RootFS  string        `json:"rootfs"`
+	WorkDir string        `json:"workdir"`
+	Command string        `json:"command"`
+	Limits  Limits        `json:"limits"`
+	Policy  SandboxPolicy `json:"policy"`
+}
+
+// NativeExecutor isolates runs with Linux namespaces, a cgroup v2 per run,
+// rlimits, a seccomp filter and a read-only chroot of the language runtime,
+// without needing a Docker daemon. It must run as root.
+//
+// The runtime root (RunSpec.RootFS) is any directory tree holding the
+// language, e.g. `docker export` of the python image, with empty /code and
+// /tmp directories for the workspace and scratch space to be mounted on.
+type NativeExecutor struct {
+	CgroupRoot string // Parent cgroup the per-run cgroups are created in
+	runs       atomic.Uint64
+}
+
+func NewNativeExecutor() (*NativeExecutor, error) {
+	if os.Geteuid() != 0 {
+		return nil, fmt.Errorf("native sandbox must run as root")
+	}
+
+	cgroupRoot := os.Getenv("SANDBOX_CGROUP")
+	if cgroupRoot == "" {
+		cgroupRoot = "/sys/fs/cgroup/codejudge"
+	}
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		return nil, fmt.Errorf("native sandbox needs cgroups v2: %v", err)
+	}
+	if err := os.MkdirAll(cgroupRoot, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %v", err)
+	}
+	// Let the per-run child cgroups limit CPU, memory and processes
+	if err := os.WriteFile(filepath.Join(cgroupRoot, "cgroup.subtree_control"), []byte("+cpu +memory +pids"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to enable cgroup controllers: %v", err)
+	}
+
+	return &NativeExecutor{CgroupRoot: cgroupRoot}, nil
+}
+
+func (n *NativeExecutor) Run(parent context.Context, spec RunSpec) (RunOutcome, error) {
+	var outcome RunOutcome
+	limits := spec.Limits
+
+	workDir, err := filepath.Abs(spec.WorkDir)
+	if err != nil {
+		return outcome, &SandboxError{fmt.Errorf("failed to get absolute path: %v", err)}
+	}
+	policy, err := runPolicy(workDir, spec)
+	if err != nil {
+		return outcome, &SandboxError{err}
+	}
+
+	config, err := json.Marshal(sandboxConfig{
+		RootFS:  spec.RootFS,
+		WorkDir: workDir,
+		Command: spec.Command,
+		Limits:  limits,
+		Policy:  policy,
+	})
+	if err != nil {
+		return outcome, &SandboxError{err}
+	}
+
+	cgroupDir := filepath.Join(n.CgroupRoot, "run-"+strconv.FormatUint(n.runs.Add(1), 10))
+	if err := createCgroup(cgroupDir, limits, policy); err != nil {
+		return outcome, &SandboxError{err}
+	}
+	defer os.Remove(cgroupDir)
+	cgroupFD, err := syscall.Open(cgroupDir, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		return outcome, &SandboxError{fmt.Errorf("failed to open cgroup: %v", err)}
+	}
+	defer syscall.Close(cgroupFD)
+
+	ctx, cancel := context.WithTimeout(parent, limits.WallTime)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe", sandboxInitArg)
+	cmd.Env = []string{sandboxConfigEnv + "=" + string(config)}
+	// New mount, PID, IPC and UTS namespaces: the program sees only its
+	// chroot and its own processes. A fresh network namespace has nothing
+	// but a downed loopback, so it also cuts off all network access.
+	cloneflags := uintptr(syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS)
+	if !policy.Network {
+		cloneflags |= syscall.CLONE_NEWNET
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  cloneflags,
+		Pdeathsig:   syscall.SIGKILL,
+		UseCgroupFD: true,
+		CgroupFD:    cgroupFD,
+	}
+
+	// sandbox-init hands the seccomp notification listener back over this
+	// socket so the calls the filter reports can be counted here
+	sockets, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return outcome, &SandboxError{fmt.Errorf("failed to create supervisor socket: %v", err)}
+	}
+	supervisorSocket := os.NewFile(uintptr(sockets[0]), "seccomp-supervisor")
+	sandboxSocket := os.NewFile(uintptr(sockets[1]), "seccomp-sandbox")
+	defer supervisorSocket.Close()
+	cmd.ExtraFiles = []*os.File{sandboxSocket}
+
+	stdout := &limitedBuffer{Limit: limits.Output, OnExceed: cancel}
+	stderr := &limitedBuffer{Limit: limits.Output, OnExceed: cancel}
+	markers, err := newMarkerFilter(stderr, spec.OnMarker, limits.Output)
+	if err != nil {
+		return outcome, &SandboxError{err}
+	}
+	cmd.Stdin = markers.Stdin()
+	cmd.Stdout = stdout
+	cmd.Stderr = markers
+
+	start := time.Now()
+	err = cmd.Start()
+	sandboxSocket.Close()
+	if err != nil {
+		return outcome, &SandboxError{fmt.Errorf("failed to start sandbox: %v", err)}
+	}
+	supervisor := superviseSeccomp(supervisorSocket)
+	err = cmd.Wait()
+	violations, started := supervisor.Stop()
+	outcome.Violations = violations
+	markers.Flush()
+	outcome.Duration = time.Since(start)
+	outcome.Stdout = stdout.String()
+	outcome.Stderr = stderr.String()
+	outcome.OutputExceeded = stdout.Exceeded || stderr.Exceeded
+
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return outcome, &SandboxError{fmt.Errorf("sandbox failed: %v", err)}
+		}
+		if parent.Err() != nil {
+			return outcome, parent.Err()
+		}
+		// sandbox-init hands over the seccomp listener right before running
+		// the command, so without it the sandbox itself failed
+		if !started && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return outcome, &SandboxError{fmt.Errorf("sandbox setup failed: %s", strings.TrimSpace(outcome.Stderr))}
+		}
+		status := exitErr.Sys().(syscall.WaitStatus)
+		outcome.ExitCode = status.ExitStatus()
+		if status.Signaled() {
+			// Mirror the shell convention so callers see e.g. 137 for SIGKILL
+			outcome.ExitCode = 128 + int(status.Signal())
+			if status.Signal() == syscall.SIGXCPU {
+				outcome.TimedOut = true
+			}
+		}
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		outcome.TimedOut = true
+	}
+
+	// "max" counts the forks refused because the cgroup was at pids.max
+	if events, err := os.ReadFile(filepath.Join(cgroupDir, "pids.events")); err == nil {
+		for _, line := range strings.Split(string(events), "\n") {
+			if count, ok := strings.CutPrefix(line, "max "); ok {
+				outcome.Violations.Forks, _ = strconv.Atoi(strings.TrimSpace(count))
+			}
+		}
+	}
+	if peak, err := os.ReadFile(filepath.Join(cgroupDir, "memory.peak")); err == nil {
+		if bytes, err := strconv.ParseInt(strings.TrimSpace(string(peak)), 10, 64); err == nil {
+			outcome.MemoryKB = bytes / 1024
+		}
+	} else if cmd.ProcessState != nil {
+		if usage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+			outcome.MemoryKB = usage.Maxrss
+		}
+	}
+
+	return outcome, nil
+}
+
+func createCgroup(dir string, limits Limits, policy SandboxPolicy) error {
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to create cgroup: %v", err)
+	}
+
+	// Like Docker's --pids-limit, a process limit of 0 means unlimited
+	pidsMax := "max"
+	if policy.ProcessLimit > 0 {
+		pidsMax = strconv.Itoa(policy.ProcessLimit)
+	}
+	settings := map[string]string{
+		"memory.max":      strconv.Itoa(limits.MemoryMB << 20),
+		"memory.swap.max": "0",
+		"pids.max":        pidsMax,
+		// Quota per 100ms period, e.g. 0.5 CPUs = 50ms
+		"cpu.max": strconv.Itoa(int(limits.CPUs*100000)) + " 100000",
+	}
+	for file, value := range settings {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+			if file == "memory.swap.max" && errors.Is(err, fs.ErrNotExist) {
+				continue // Kernel built without swap accounting
+			}
+			return fmt.Errorf("failed to set %s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+// sandboxInit runs as PID 1 of the new namespaces. It builds the chroot,
+// applies rlimits, drops to the policy's unprivileged user (which also drops
+// every capability), installs the seccomp filter and finally replaces itself
+// with the user's command. It never returns.
+func sandboxInit() {
+	fail := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, "sandbox: "+format+"\n", args...)
+		os.Exit(125)
+	}
+
+	// Everything from here to exec must happen on one thread: seccomp
+	// filters are per-thread and survive exec only on the calling thread
+	runtime.LockOSThread()
+
+	var config sandboxConfig
+	if err := json.Unmarshal([]byte(os.Getenv(sandboxConfigEnv)), &config); err != nil {
+		fail("bad config: %v", err)
+	}
+	root := config.RootFS
+	policy := config.Policy
+
+	mounts := []struct {
+		source, target, fstype string
+		flags                  uintptr
+		data                   string
+	}{
+		// Keep our mounts from propagating back to the host
+		{"", "/", "", syscall.MS_REC | syscall.MS_PRIVATE, ""},
+		{root, root, "", syscall.MS_BIND | syscall.MS_REC, ""},
+		{config.WorkDir, filepath.Join(root, "code"), "", syscall.MS_BIND, ""},
+		{"tmpfs", filepath.Join(root, "tmp"), "tmpfs", syscall.MS_NOSUID | syscall.MS_NODEV, "size=" + policy.ScratchSize + ",mode=1777"},
+	}
+	for _, m := range mounts {
+		if err := syscall.Mount(m.source, m.target, m.fstype, m.flags, m.data); err != nil {
+			fail("mount %s: %v", m.target, err)
+		}
+	}
+	for _, dev := range []string{"/dev/null", "/dev/zero", "/dev/urandom"} {
+		// Only bind devices the runtime image has a placeholder for
+		if _, err := os.Stat(filepath.Join(root, dev)); err == nil {
+			if err := syscall.Mount(dev, filepath.Join(root, dev), "", syscall.MS_BIND, ""); err != nil {
+				fail("mount %s: %v", dev, err)
+			}
+		}
+	}
+	// /code and /tmp are separate mounts, so making the runtime read-only
+	// leaves them writable unless the policy locks the workspace too
+	if policy.ReadOnlyRoot {
+		if err := syscall.Mount("", root, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID, ""); err != nil {
+			fail("remount read-only: %v", err)
+		}
+	}
+	if policy.ReadOnlyWorkspace {
+		if err := syscall.Mount("", filepath.Join(root, "code"), "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, ""); err != nil {
+			fail("remount workspace read-only: %v", err)
+		}
+	}
+
+	if err := syscall.Chroot(root); err != nil {
+		fail("chroot: %v", err)
+	}
+	if err := syscall.Chdir("/code"); err != nil {
+		fail("chdir: %v", err)
+	}
+
+	limits := config.Limits
+	cpuSeconds := uint64((limits.CPUTime + time.Second - 1) / time.Second)
+	rlimits := map[int]unix.Rlimit{
+		unix.RLIMIT_CPU:   {Cur: cpuSeconds, Max: cpuSeconds},
+		unix.RLIMIT_FSIZE: {Cur: uint64(limits.FileSize), Max: uint64(limits.FileSize)},
+		unix.RLIMIT_CORE:  {Cur: 0, Max: 0},
+		// Address space is left alone: runtimes like the JVM reserve far
+		// more than they touch and the cgroup's memory.max already bounds
+		// what is used
+	}
+	for _, name := range policy.UlimitNames() {
+		resource, ok := rlimitResources[name]
+		if !ok {
+			fail("unknown ulimit %q", name)
+		}
+		soft, hard, found := strings.Cut(policy.Ulimits[name], ":")
+		if !found {
+			hard = soft
+		}
+		cur, err1 := strconv.ParseUint(soft, 10, 64)
+		hardLimit, err2 := strconv.ParseUint(hard, 10, 64)
+		if err1 != nil || err2 != nil {
+			fail("bad ulimit %s=%s", name, policy.Ulimits[name])
+		}
+		rlimits[resource] = unix.Rlimit{Cur: cur, Max: hardLimit}
+	}
+	for resource, rl := range rlimits {
+		if err := unix.Setrlimit(resource, &rl); err != nil {
+			fail("setrlimit %d: %v", resource, err)
+		}
+	}
+
+	uid, gid, err := policy.UserIDs()
+	if err != nil {
+		fail("%v", err)
+	}
+	if err := syscall.Setgroups(nil); err != nil {
+		fail("setgroups: %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		fail("setgid: %v", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		fail("setuid: %v", err)
+	}
+
+	listener, err := installSeccomp(policy)
+	if err != nil {
+		fail("seccomp: %v", err)
+	}
+	if err := unix.Sendmsg(supervisorFD, []byte{0}, unix.UnixRights(listener), nil, 0); err != nil {
+		fail("send seccomp listener: %v", err)
+	}
+	unix.Close(listener)
+	unix.Close(supervisorFD)
+
+	env := []string{"PATH=/usr/local/bin:/usr/bin:/bin", "HOME=/tmp", "LANG=C.UTF-8", "PYTHONDONTWRITEBYTECODE=1"}
+	err = syscall.Exec("/bin/sh", []string{"sh", "-c", config.Command}, env)
+	fail("exec: %v", err)
+}
+
+// rlimitResources maps policy ulimit names to rlimit resources
+var rlimitResources = map[string]int{
+	"core":   unix.RLIMIT_CORE,
+	"cpu":    unix.RLIMIT_CPU,
+	"fsize":  unix.RLIMIT_FSIZE,
+	"nofile": unix.RLIMIT_NOFILE,
+	"nproc":  unix.RLIMIT_NPROC,
+	"stack":  unix.RLIMIT_STACK,
+	"as":     unix.RLIMIT_AS,
+}
+
+// seccompDenied are syscalls untrusted code never needs. They fail with
+// EPERM rather than killing the process so the program sees a normal error.
+var seccompDenied = []uintptr{
+	unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_CHROOT,
+	unix.SYS_SETNS, unix.SYS_UNSHARE, unix.SYS_REBOOT, unix.SYS_KEXEC_LOAD,
+	unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE,
+	unix.SYS_SWAPON, unix.SYS_SWAPOFF, unix.SYS_BPF, unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_KEYCTL, unix.SYS_ADD_KEY, unix.SYS_REQUEST_KEY, unix.SYS_USERFAULTFD,
+}
+
+// pathSyscall is a syscall that writes to the paths in its arguments. The
+// filter reports these to the seccomp supervisor so writes outside the
+// scratch directory can be counted.
+type pathSyscall struct {
+	nr    uintptr
+	paths [][2]int // Pairs of (dirfd argument, path argument); dirfd -1 for calls relative to the working directory
+	flags int      // Argument holding open flags if only some calls write, else -1
+}
+
+var pathSyscalls = append([]pathSyscall{
+	{unix.SYS_OPENAT, [][2]int{{0, 1}}, 2},
+	{unix.SYS_MKDIRAT, [][2]int{{0, 1}}, -1},
+	{unix.SYS_MKNODAT, [][2]int{{0, 1}}, -1},
+	{unix.SYS_UNLINKAT, [][2]int{{0, 1}}, -1},
+	{unix.SYS_RENAMEAT, [][2]int{{0, 1}, {2, 3}}, -1},
+	{unix.SYS_RENAMEAT2, [][2]int{{0, 1}, {2, 3}}, -1},
+	{unix.SYS_LINKAT, [][2]int{{2, 3}}, -1},
+	{unix.SYS_SYMLINKAT, [][2]int{{1, 2}}, -1},
+	{unix.SYS_TRUNCATE, [][2]int{{-1, 0}}, -1},
+}, legacyPathSyscalls...)
+
+// openWriteFlags are the open flags that make an open a write
+const openWriteFlags = unix.O_WRONLY | unix.O_RDWR | unix.O_CREAT | unix.O_TRUNC
+
+// installSeccomp installs the filter and returns its notification listener.
+// Internet sockets (when the policy has no network) and path writes are
+// reported to the listener; the rest is allowed or denied outright.
+func installSeccomp(policy SandboxPolicy) (int, error) {
+	var arch uint32
+	switch runtime.GOARCH {
+	case "amd64":
+		arch = unix.AUDIT_ARCH_X86_64
+	case "arm64":
+		arch = unix.AUDIT_ARCH_AARCH64
+	default:
+		return -1, fmt.Errorf("unsupported architecture %s", runtime.GOARCH)
+	}
+
+	const (
+		offsetNR   = 0  // offsetof(struct seccomp_data, nr)
+		offsetArch = 4  // offsetof(struct seccomp_data, arch)
+		offsetArgs = 16 // offsetof(struct seccomp_data, args), low 32 bits on little-endian
+		x32Bit     = 0x40000000
+	)
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+	}
+	loadArg := func(arg int) unix.SockFilter {
+		return stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, uint32(offsetArgs+8*arg))
+	}
+	denied := unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+	allow := stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ALLOW)
+	notify := stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_USER_NOTIF)
+
+	filter := []unix.SockFilter{
+		// Kill anything not using the native syscall ABI
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetNR),
+		jump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32Bit, 0, 1),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+	}
+	for _, nr := range seccompDenied {
+		filter = append(filter,
+			jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, uint32(nr), 0, 1),
+			stmt(unix.BPF_RET|unix.BPF_K, denied),
+		)
+	}
+	if !policy.Network {
+		filter = append(filter,
+			jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, unix.SYS_SOCKET, 0, 5),
+			loadArg(0),
+			jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, unix.AF_INET, 2, 0),
+			jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, unix.AF_INET6, 1, 0),
+			allow,
+			notify,
+		)
+	}
+	for _, call := range pathSyscalls {
+		if call.flags < 0 {
+			filter = append(filter,
+				jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, uint32(call.nr), 0, 1),
+				notify,
+			)
+			continue
+		}
+		filter = append(filter,
+			jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, uint32(call.nr), 0, 4),
+			loadArg(call.flags),
+			jump(unix.BPF_JMP|unix.BPF_JSET|unix.BPF_K, openWriteFlags, 0, 1),
+			notify,
+			allow,
+		)
+	}
+	filter = append(filter, allow)
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return -1, err
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	listener, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_NEW_LISTENER, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(listener), nil
+}
+
+// seccompNotif and seccompNotifResp mirror struct seccomp_notif and struct
+// seccomp_notif_resp from <linux/seccomp.h>
+type seccompNotif struct {
+	ID    uint64
+	Pid   uint32
+	Flags uint32
+	Data  struct {
+		Nr   int32
+		Arch uint32
+		IP   uint64
+		Args [6]uint64
+	}
+}
+
+type seccompNotifResp struct {
+	ID    uint64
+	Val   int64
+	Error int32
+	Flags uint32
+}
+
+// seccompSupervisor counts the calls the seccomp filter reports. It only
+// watches: every call is let through, and the read-only mounts and the
+// network namespace are what refuse them. Needs Linux 5.5 or later.
+type seccompSupervisor struct {
+	violations Violations
+	started    bool // The listener arrived, so sandbox-init got as far as exec
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// superviseSeccomp receives the listener from sandbox-init over socket and
+// serves its notifications until Stop
+func superviseSeccomp(socket *os.File) *seccompSupervisor {
+	s := &seccompSupervisor{stop: make(chan struct{}), done: make(chan struct{})}
+	go s.run(socket)
+	return s
+}
+
+// Stop ends supervision once the run has exited. It returns the counts and
+// whether the sandbox was set up far enough to run the command.
+func (s *seccompSupervisor) Stop() (Violations, bool) {
+	close(s.stop)
+	<-s.done
+	return s.violations, s.started
+}
+
+func (s *seccompSupervisor) run(socket *os.File) {
+	defer close(s.done)
+
+	// A failed sandbox-init closes its end without sending anything
+	buf := make([]byte, 1)
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(int(socket.Fd()), buf, oob, 0)
+	if err != nil || oobn == 0 {
+		return
+	}
+	messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(messages) == 0 {
+		return
+	}
+	fds, err := unix.ParseUnixRights(&messages[0])
+	if err != nil || len(fds) == 0 {
+		return
+	}
+	listener := fds[0]
+	defer unix.Close(listener)
+	s.started = true
+
+	pollFDs := []unix.PollFd{{Fd: int32(listener), Events: unix.POLLIN}}
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		n, err := unix.Poll(pollFDs, 100)
+		if err == unix.EINTR || n == 0 {
+			continue
+		}
+		if err != nil || pollFDs[0].Revents&unix.POLLIN == 0 {
+			return // POLLHUP: every sandboxed process has exited
+		}
+		s.handle(listener)
+	}
+}
+
+func (s *seccompSupervisor) handle(listener int) {
+	var req seccompNotif
+	if seccompIoctl(listener, unix.SECCOMP_IOCTL_NOTIF_RECV, unsafe.Pointer(&req)) != nil {
+		return // The caller died before we got to it
+	}
+	if req.Data.Nr == unix.SYS_SOCKET {
+		s.violations.Network++
+	} else if s.writesReadOnly(listener, req) {
+		s.violations.Filesystem++
+	}
+	resp := seccompNotifResp{ID: req.ID, Flags: unix.SECCOMP_USER_NOTIF_FLAG_CONTINUE}
+	seccompIoctl(listener, unix.SECCOMP_IOCTL_NOTIF_SEND, unsafe.Pointer(&resp))
+}
+
+// writesReadOnly reports whether a path syscall targets a read-only mount,
+// which is everywhere but the scratch directory (and the workspace, if the
+// policy leaves it writable)
+func (s *seccompSupervisor) writesReadOnly(listener int, req seccompNotif) bool {
+	for _, call := range pathSyscalls {
+		if uintptr(req.Data.Nr) != call.nr {
+			continue
+		}
+		for _, arg := range call.paths {
+			path, err := readProcessString(req.Pid, req.Data.Args[arg[1]])
+			// The process could have been replaced by another with the same
+			// PID while we read its memory
+			if err != nil || seccompIoctl(listener, unix.SECCOMP_IOCTL_NOTIF_ID_VALID, unsafe.Pointer(&req.ID)) != nil {
+				return false
+			}
+			// Resolve the path from the process's own root or directory
+			proc := "/proc/" + strconv.Itoa(int(req.Pid))
+			base := proc + "/cwd"
+			if strings.HasPrefix(path, "/") {
+				base = proc + "/root"
+			} else if arg[0] >= 0 && int32(req.Data.Args[arg[0]]) != unix.AT_FDCWD {
+				base = proc + "/fd/" + strconv.Itoa(int(int32(req.Data.Args[arg[0]])))
+			}
+			if onReadOnlyMount(base, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// onReadOnlyMount reports whether path, relative to base, is on a read-only
+// mount. A file about to be created is judged by the nearest directory
+// above it that exists.
+func onReadOnlyMount(base, path string) bool {
+	for {
+		var st unix.Statfs_t
+		if unix.Statfs(base+"/"+path, &st) == nil {
+			return st.Flags&unix.ST_RDONLY != 0
+		}
+		trimmed := strings.TrimRight(path, "/")
+		i := strings.LastIndex(trimmed, "/")
+		if i < 0 {
+			if path == "." {
+				return false
+			}
+			path = "."
+			continue
+		}
+		path = trimmed[:i+1]
+	}
+}
+
+// readProcessString reads a NUL-terminated string from another process
+func readProcessString(pid uint32, addr uint64) (string, error) {
+	mem, err := os.Open("/proc/" + strconv.Itoa(int(pid)) + "/mem")
+	if err != nil {
+		return "", err
+	}
+	defer mem.Close()
+
+	var str []byte
+	buf := make([]byte, 256)
+	for len(str) < unix.PathMax {
+		// Aligned chunks never cross into a page that may be unmapped
+		offset := addr + uint64(len(str))
+		n, err := mem.ReadAt(buf[:256-offset%256], int64(offset))
+		if i := bytes.IndexByte(buf[:n], 0); i >= 0 {
+			return string(append(str, buf[:i]...)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		str = append(str, buf[:n]...)
+	}
+	return "", fmt.Errorf("string too long")
+}
+
+func seccompIoctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
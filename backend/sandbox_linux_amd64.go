@@ -0,0 +1,16 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// legacyPathSyscalls are the path writes amd64 still has older forms of
+var legacyPathSyscalls = []pathSyscall{
+	{unix.SYS_OPEN, [][2]int{{-1, 0}}, 1},
+	{unix.SYS_CREAT, [][2]int{{-1, 0}}, -1},
+	{unix.SYS_MKDIR, [][2]int{{-1, 0}}, -1},
+	{unix.SYS_MKNOD, [][2]int{{-1, 0}}, -1},
+	{unix.SYS_UNLINK, [][2]int{{-1, 0}}, -1},
+	{unix.SYS_RMDIR, [][2]int{{-1, 0}}, -1},
+	{unix.SYS_RENAME, [][2]int{{-1, 0}, {-1, 1}}, -1},
+	{unix.SYS_LINK, [][2]int{{-1, 1}}, -1},
+	{unix.SYS_SYMLINK, [][2]int{{-1, 1}}, -1},
+}
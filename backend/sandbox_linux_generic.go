@@ -0,0 +1,6 @@
+//go:build linux && !amd64
+
+package main
+
+// Other architectures only have the *at forms of path syscalls
+var legacyPathSyscalls []pathSyscall
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+const sandboxInitArg = "sandbox-init"
+
+// NativeExecutor is only available on Linux
+type NativeExecutor struct{}
+
+func NewNativeExecutor() (*NativeExecutor, error) {
+	return nil, fmt.Errorf("native sandbox requires Linux")
+}
+
+func (n *NativeExecutor) Run(ctx context.Context, spec RunSpec) (RunOutcome, error) {
+	return RunOutcome{}, fmt.Errorf("native sandbox requires Linux")
+}
+
+func sandboxInit() {
+	fmt.Fprintln(os.Stderr, "sandbox: native sandbox requires Linux")
+	os.Exit(125)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// The native sandbox re-executes the running binary, which under go
+	// test is the test binary
+	if len(os.Args) > 1 && os.Args[1] == sandboxInitArg {
+		sandboxInit()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func TestDockerRunArgs(t *testing.T) {
+	spec := RunSpec{Command: "python solution.py", Image: "python:3.11", Limits: DefaultLimits}
+	args := dockerRunArgs("/work/run-1", "codejudge-1", spec, DefaultSandboxPolicy)
+
+	for _, want := range []string{
+		"--rm",
+		"--name=codejudge-1",
+		"--network=none",
+		"--pids-limit=64",
+		"--read-only",
+		"--cap-drop=ALL",
+		"--security-opt=no-new-privileges",
+		"--user=65534:65534",
+		"--memory=128m",
+		"--memory-swap=128m",
+		"/tmp:rw,exec,nosuid,nodev,size=16m",
+		"/work/run-1:/code:ro",
+		"nofile=64:64",
+	} {
+		if !slices.Contains(args, want) {
+			t.Errorf("docker args %v are missing %q", args, want)
+		}
+	}
+	if got := args[len(args)-3:]; !slices.Equal(got, []string{"sh", "-c", spec.Command}) {
+		t.Errorf("docker args end with %v, want the command run by sh", got)
+	}
+
+	open := DefaultSandboxPolicy
+	open.Network = true
+	open.ProcessLimit = 0
+	open.ReadOnlyRoot = false
+	open.ReadOnlyWorkspace = false
+	args = dockerRunArgs("/work/run-1", "codejudge-1", spec, open)
+	for _, unwanted := range []string{"--network=none", "--read-only", "/work/run-1:/code:ro"} {
+		if slices.Contains(args, unwanted) {
+			t.Errorf("docker args %v have %q although the policy allows it", args, unwanted)
+		}
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--pids-limit") {
+			t.Errorf("docker args have %q with no process limit", arg)
+		}
+	}
+}
+
+func TestDockerRunArgsPassStdinForMarkers(t *testing.T) {
+	spec := RunSpec{Command: "python runner.py", Image: "python:3.11", Limits: DefaultLimits}
+	if args := dockerRunArgs("/work/run-1", "codejudge-1", spec, DefaultSandboxPolicy); slices.Contains(args, "--interactive") {
+		t.Errorf("docker args %v attach stdin without markers", args)
+	}
+	spec.OnMarker = func(string) {}
+	if args := dockerRunArgs("/work/run-1", "codejudge-1", spec, DefaultSandboxPolicy); !slices.Contains(args, "--interactive") {
+		t.Errorf("docker args %v don't attach stdin for the marker prefix", args)
+	}
+}
+
+func TestMarkerFilter(t *testing.T) {
+	var stderr strings.Builder
+	var markers []string
+	f, err := newMarkerFilter(&stderr, func(marker string) { markers = append(markers, marker) }, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, _ := io.ReadAll(f.Stdin())
+	prefix := strings.TrimSpace(string(stdin))
+	if !strings.HasPrefix(prefix, judgeMarker) || prefix == judgeMarker {
+		t.Fatalf("marker prefix %q has no nonce", prefix)
+	}
+
+	// The program's own lines, a forged marker, and a real marker behind
+	// an unfinished line
+	io.WriteString(f, "hello\n")
+	io.WriteString(f, judgeMarker+"test {\"status\":\"ok\"}\n")
+	io.WriteString(f, "no newline")
+	io.WriteString(f, prefix+"test {\"status\":\"ok\",\"result\":1}\n")
+	io.WriteString(f, "bye")
+	f.Flush()
+
+	if want := []string{`test {"status":"ok","result":1}`}; !slices.Equal(markers, want) {
+		t.Errorf("markers %q, want %q", markers, want)
+	}
+	if want := "hello\n" + judgeMarker + "test {\"status\":\"ok\"}\nno newlinebye"; stderr.String() != want {
+		t.Errorf("stderr %q, want %q", stderr.String(), want)
+	}
+}
+
+func TestMarkerFilterWithoutMarkers(t *testing.T) {
+	var stderr strings.Builder
+	f, err := newMarkerFilter(&stderr, nil, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Stdin() != nil {
+		t.Error("a run without markers is given stdin")
+	}
+	io.WriteString(f, judgeMarker+"test {}\n")
+	f.Flush()
+	if stderr.String() != judgeMarker+"test {}\n" {
+		t.Errorf("stderr %q, want it passed on untouched", stderr.String())
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	exceeded := 0
+	b := &limitedBuffer{Limit: 8, OnExceed: func() { exceeded++ }}
+	for _, write := range []string{"hello", " world", "!", "more"} {
+		// Every write reports success so the pipe keeps draining
+		if n, err := io.WriteString(b, write); n != len(write) || err != nil {
+			t.Errorf("write of %q = %d, %v", write, n, err)
+		}
+	}
+	if b.String() != "hello wo" || !b.Exceeded || exceeded != 1 {
+		t.Errorf("kept %q, exceeded %v, called back %d times; want the first 8 bytes and one call", b.String(), b.Exceeded, exceeded)
+	}
+
+	b = &limitedBuffer{Limit: 5}
+	io.WriteString(b, "hello")
+	if b.Exceeded {
+		t.Error("output of exactly the limit exceeded it")
+	}
+}
+
+func TestClassifyViolation(t *testing.T) {
+	tests := []struct {
+		violations Violations
+		want       string
+	}{
+		{Violations{}, ""},
+		{Violations{Forks: 3}, StatusProcessLimit},
+		{Violations{Network: 1}, StatusNetworkDenied},
+		{Violations{Filesystem: 2}, StatusFilesystemDenied},
+		// A refused fork is what makes the rest fail
+		{Violations{Forks: 1, Network: 1, Filesystem: 1}, StatusProcessLimit},
+		{Violations{Network: 1, Filesystem: 1}, StatusNetworkDenied},
+	}
+	for _, tt := range tests {
+		if got := classifyViolation(tt.violations); got != tt.want {
+			t.Errorf("classifyViolation(%+v) = %q, want %q", tt.violations, got, tt.want)
+		}
+	}
+}
+
+// sandboxPrograms try each thing the policy forbids. Each must fail, and
+// the verdict comes from what the sandbox saw, not from what was printed.
+var sandboxPrograms = []struct {
+	name    string
+	source  string
+	verdict string // "" for a clean run
+	fails   bool
+}{
+	{"scratch", "open('/tmp/ok', 'w').write('x')\nprint('ok')\n", "", false},
+	{"fork", "import os, time\nfor _ in range(200):\n    if os.fork() == 0:\n        time.sleep(3)\n        os._exit(0)\n", StatusProcessLimit, true},
+	{"network", "import socket\nsocket.create_connection(('1.1.1.1', 80), timeout=1)\n", StatusNetworkDenied, true},
+	{"filesystem", "open('/etc/judge-test', 'w').write('x')\n", StatusFilesystemDenied, true},
+	{"workspace", "open('/code/judge-test', 'w').write('x')\n", StatusFilesystemDenied, true},
+	// Printing what a refused operation prints changes nothing
+	{"spoofed", "raise SystemExit('[Errno 30] Read-only file system: fork: retry')\n", "", true},
+}
+
+// runSandboxProgram runs a Python program in a fresh workspace
+func runSandboxProgram(t *testing.T, executor Executor, image, rootfs, source string) RunOutcome {
+	t.Helper()
+	workDir := t.TempDir()
+	// The program runs as the unprivileged sandbox user
+	if err := os.Chmod(workDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "prog.py"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	limits := DefaultLimits
+	limits.WallTime = 10 * time.Second
+	outcome, err := executor.Run(context.Background(), RunSpec{
+		WorkDir: workDir,
+		Command: "python prog.py",
+		Image:   image,
+		RootFS:  rootfs,
+		Limits:  limits,
+	})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	return outcome
+}
+
+func TestNativeExecutorPolicy(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("the native sandbox needs root")
+	}
+	rootfs := os.Getenv("SANDBOX_TEST_ROOTFS")
+	if rootfs == "" {
+		rootfs = "/opt/codejudge/rootfs/python"
+	}
+	if _, err := os.Stat(filepath.Join(rootfs, "bin", "sh")); err != nil {
+		t.Skipf("no Python runtime root at %s (set SANDBOX_TEST_ROOTFS)", rootfs)
+	}
+	if os.Getenv("SANDBOX_CGROUP") == "" {
+		t.Setenv("SANDBOX_CGROUP", "/sys/fs/cgroup/codejudge-test")
+	}
+	executor, err := NewNativeExecutor()
+	if err != nil {
+		t.Skipf("native sandbox unavailable: %v", err)
+	}
+
+	for _, program := range sandboxPrograms {
+		t.Run(program.name, func(t *testing.T) {
+			outcome := runSandboxProgram(t, executor, "", rootfs, program.source)
+			if failed := outcome.ExitCode != 0; failed != program.fails {
+				t.Fatalf("exit code %d, stderr %q", outcome.ExitCode, outcome.Stderr)
+			}
+			if got := classifyViolation(outcome.Violations); got != program.verdict {
+				t.Errorf("verdict %q (violations %+v), want %q", got, outcome.Violations, program.verdict)
+			}
+		})
+	}
+
+	// Only a sandbox that never ran the command is the sandbox's fault
+	t.Run("exit 125", func(t *testing.T) {
+		outcome := runSandboxProgram(t, executor, "", rootfs, "raise SystemExit(125)\n")
+		if outcome.ExitCode != 125 {
+			t.Errorf("exit code %d, want the program's 125", outcome.ExitCode)
+		}
+	})
+	t.Run("setup failure", func(t *testing.T) {
+		_, err := executor.Run(context.Background(), RunSpec{
+			WorkDir: t.TempDir(),
+			Command: "true",
+			RootFS:  filepath.Join(t.TempDir(), "missing"),
+			Limits:  DefaultLimits,
+		})
+		var sandboxErr *SandboxError
+		if !errors.As(err, &sandboxErr) {
+			t.Errorf("error %v, want a SandboxError", err)
+		}
+	})
+}
+
+func TestDockerExecutorPolicy(t *testing.T) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is not installed")
+	}
+	if err := exec.Command("docker", "image", "inspect", "python:3.11").Run(); err != nil {
+		t.Skip("docker or the python:3.11 image is unavailable")
+	}
+
+	// Docker can't report what it refused, so only check it is refused
+	for _, program := range sandboxPrograms {
+		t.Run(program.name, func(t *testing.T) {
+			outcome := runSandboxProgram(t, &DockerExecutor{}, "python:3.11", "", program.source)
+			if failed := outcome.ExitCode != 0; failed != program.fails {
+				t.Fatalf("exit code %d, stderr %q", outcome.ExitCode, outcome.Stderr)
+			}
+		})
+	}
+}
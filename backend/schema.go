@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TypeSpec is a parsed signature type string such as "List[List[int]]"
+type TypeSpec struct {
+	Name string     // "int", "float", "str", "bool", "list", "dict" or "optional"
+	Args []TypeSpec // Element types for list, dict and optional
+}
+
+func (t TypeSpec) String() string {
+	switch t.Name {
+	case "list":
+		return "List[" + t.Args[0].String() + "]"
+	case "dict":
+		return "Dict[" + t.Args[0].String() + ", " + t.Args[1].String() + "]"
+	case "optional":
+		return "Optional[" + t.Args[0].String() + "]"
+	}
+	return t.Name
+}
+
+// ParseType parses a Python-style type annotation. Names are case-insensitive
+// so "list[int]" and "List[int]" are the same type.
+func ParseType(s string) (TypeSpec, error) {
+	t, rest, err := parseTypePrefix(strings.TrimSpace(s))
+	if err != nil {
+		return TypeSpec{}, err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return TypeSpec{}, fmt.Errorf("unexpected %q after type in %q", rest, s)
+	}
+	return t, nil
+}
+
+func parseTypePrefix(s string) (TypeSpec, string, error) {
+	end := strings.IndexAny(s, "[],")
+	if end == -1 {
+		end = len(s)
+	}
+	name := strings.ToLower(strings.TrimSpace(s[:end]))
+	rest := strings.TrimSpace(s[end:])
+
+	var arity int
+	switch name {
+	case "int", "float", "str", "bool":
+		return TypeSpec{Name: name}, rest, nil
+	case "list", "optional":
+		arity = 1
+	case "dict":
+		arity = 2
+	case "":
+		return TypeSpec{}, "", fmt.Errorf("missing type name")
+	default:
+		return TypeSpec{}, "", fmt.Errorf("unknown type %q", s[:end])
+	}
+
+	if !strings.HasPrefix(rest, "[") {
+		return TypeSpec{}, "", fmt.Errorf("type %q needs %d type argument(s)", name, arity)
+	}
+	rest = rest[1:]
+
+	t := TypeSpec{Name: name}
+	for i := 0; i < arity; i++ {
+		if i > 0 {
+			if !strings.HasPrefix(rest, ",") {
+				return TypeSpec{}, "", fmt.Errorf("type %q needs %d type arguments", name, arity)
+			}
+			rest = strings.TrimSpace(rest[1:])
+		}
+		arg, r, err := parseTypePrefix(rest)
+		if err != nil {
+			return TypeSpec{}, "", err
+		}
+		t.Args = append(t.Args, arg)
+		rest = r
+	}
+
+	if !strings.HasPrefix(rest, "]") {
+		return TypeSpec{}, "", fmt.Errorf("unclosed type arguments for %q", name)
+	}
+	if name == "dict" && t.Args[0].Name != "str" && t.Args[0].Name != "int" {
+		return TypeSpec{}, "", fmt.Errorf("dict keys must be str or int, got %s", t.Args[0])
+	}
+	return t, strings.TrimSpace(rest[1:]), nil
+}
+
+// CheckValue reports whether a decoded JSON value conforms to the type.
+// The returned error names the offending element by path (e.g. "[2][0]").
+func (t TypeSpec) CheckValue(v interface{}) error {
+	return t.checkValue(v, "")
+}
+
+func (t TypeSpec) checkValue(v interface{}, path string) error {
+	mismatch := func() error {
+		return pathError(path, fmt.Sprintf("expected %s, got %s", t, jsonKind(v)))
+	}
+
+	switch t.Name {
+	case "optional":
+		if v == nil {
+			return nil
+		}
+		return t.Args[0].checkValue(v, path)
+	case "int":
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) {
+			return mismatch()
+		}
+	case "float":
+		if _, ok := v.(float64); !ok {
+			return mismatch()
+		}
+	case "str":
+		if _, ok := v.(string); !ok {
+			return mismatch()
+		}
+	case "bool":
+		if _, ok := v.(bool); !ok {
+			return mismatch()
+		}
+	case "list":
+		items, ok := v.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for i, item := range items {
+			if err := t.Args[0].checkValue(item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case "dict":
+		entries, ok := v.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for key, item := range entries {
+			if t.Args[0].Name == "int" {
+				if _, err := strconv.Atoi(key); err != nil {
+					return pathError(path, fmt.Sprintf("dict key %q is not an int", key))
+				}
+			}
+			if err := t.Args[1].checkValue(item, path+"["+strconv.Quote(key)+"]"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func pathError(path, msg string) error {
+	if path == "" {
+		return fmt.Errorf("%s", msg)
+	}
+	return fmt.Errorf("%s: %s", path, msg)
+}
+
+func jsonKind(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		if n == math.Trunc(n) {
+			return "int"
+		}
+		return "float"
+	case string:
+		return "str"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "dict"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// ValidateProblemSchema checks that a function-based problem's signature
+// parses and that every test case matches it: input keys must equal the
+// parameter names, and inputs and outputs must conform to the declared types.
+// Template overrides must name supported languages and tags must be few and
+// short. IO-based problems (no signature) only have those checked.
+func ValidateProblemSchema(problem Problem) []string {
+	var errs []string
+
+	overrides, err := parseTemplateOverrides(problem.TemplatesJSON)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for id := range overrides {
+		lang, err := GetLanguage(id)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("templates_json: unsupported language %q", id))
+		} else if problem.SignatureJSON != "" && !lang.SupportsFunctions() {
+			errs = append(errs, fmt.Sprintf("templates_json: %s can't be used for function-based problems", lang.Name))
+		}
+	}
+	sort.Strings(errs)
+
+	if len(problem.Tags) > MaxProblemTags {
+		errs = append(errs, fmt.Sprintf("tags: at most %d are allowed", MaxProblemTags))
+	}
+	for _, tag := range problem.Tags {
+		if len(tag) > MaxProblemTagLen || strings.Contains(tag, ",") {
+			errs = append(errs, fmt.Sprintf("tags: %q must be at most %d characters without commas", tag, MaxProblemTagLen))
+		}
+	}
+
+	if problem.SignatureJSON == "" {
+		return errs
+	}
+
+	var signature ProblemSignature
+	if err := json.Unmarshal([]byte(problem.SignatureJSON), &signature); err != nil {
+		return append(errs, "signature_json: "+err.Error())
+	}
+	if signature.FunctionName == "" {
+		errs = append(errs, "signature: function_name is required")
+	}
+
+	paramTypes := make(map[string]TypeSpec)
+	for i, param := range signature.Parameters {
+		if param.Name == "" {
+			errs = append(errs, fmt.Sprintf("signature: parameter %d has no name", i+1))
+			continue
+		}
+		if _, dup := paramTypes[param.Name]; dup {
+			errs = append(errs, fmt.Sprintf("signature: duplicate parameter %q", param.Name))
+			continue
+		}
+		t, err := ParseType(param.Type)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("signature: parameter %q: %v", param.Name, err))
+			continue
+		}
+		paramTypes[param.Name] = t
+	}
+
+	returnType, err := ParseType(signature.ReturnType)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("signature: return_type: %v", err))
+	}
+	if len(errs) > 0 {
+		// Test cases can't be checked against a broken signature
+		return errs
+	}
+
+	if strings.TrimSpace(problem.TestCasesJSON) == "" {
+		return append(errs, "test_cases_json: at least one test case is required")
+	}
+	var testCases []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(problem.TestCasesJSON), &testCases); err != nil {
+		return append(errs, "test_cases_json: "+err.Error())
+	}
+	if len(testCases) == 0 {
+		return append(errs, "test_cases_json: at least one test case is required")
+	}
+
+	for i, tc := range testCases {
+		prefix := fmt.Sprintf("test %d", i+1)
+
+		rawInput, ok := tc["input"]
+		if !ok {
+			errs = append(errs, prefix+": missing \"input\"")
+		} else {
+			var input map[string]interface{}
+			if err := json.Unmarshal(rawInput, &input); err != nil || input == nil {
+				errs = append(errs, prefix+": \"input\" must be an object keyed by parameter name")
+			} else {
+				for _, param := range signature.Parameters {
+					value, ok := input[param.Name]
+					if !ok {
+						errs = append(errs, fmt.Sprintf("%s: missing input %q", prefix, param.Name))
+						continue
+					}
+					if err := paramTypes[param.Name].CheckValue(value); err != nil {
+						errs = append(errs, fmt.Sprintf("%s: input %q: %v", prefix, param.Name, err))
+					}
+				}
+				var unexpected []string
+				for name := range input {
+					if _, ok := paramTypes[name]; !ok {
+						unexpected = append(unexpected, name)
+					}
+				}
+				sort.Strings(unexpected)
+				for _, name := range unexpected {
+					errs = append(errs, fmt.Sprintf("%s: unexpected input %q", prefix, name))
+				}
+			}
+		}
+
+		rawOutput, ok := tc["output"]
+		if !ok {
+			errs = append(errs, prefix+": missing \"output\"")
+			continue
+		}
+		var output interface{}
+		if err := json.Unmarshal(rawOutput, &output); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: output: %v", prefix, err))
+			continue
+		}
+		if err := returnType.CheckValue(output); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: output: %v", prefix, err))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseType(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"int", "int"},
+		{" Float ", "float"},
+		{"List[int]", "List[int]"},
+		{"list[ list[str] ]", "List[List[str]]"},
+		{"Dict[str, List[int]]", "Dict[str, List[int]]"},
+		{"dict[int,bool]", "Dict[int, bool]"},
+		{"Optional[Dict[str, int]]", "Optional[Dict[str, int]]"},
+	} {
+		got, err := ParseType(tt.in)
+		if err != nil || got.String() != tt.want {
+			t.Errorf("ParseType(%q) = %v, %v; want %s", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"integer",
+		"List",             // No element type
+		"List[]",           // Empty element type
+		"List[int",         // Unclosed
+		"List[int]]",       // Trailing bracket
+		"Dict[str]",        // One type argument short
+		"Dict[float, int]", // Keys are str or int
+		"Optional[int, str]",
+		"int int",
+	} {
+		if got, err := ParseType(in); err == nil {
+			t.Errorf("ParseType(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestValidateProblemSchema(t *testing.T) {
+	signature := func(returnType string, params ...string) string {
+		var parameters []string
+		for _, param := range params {
+			name, typ, _ := strings.Cut(param, ":")
+			parameters = append(parameters, `{"name":"`+name+`","type":"`+typ+`"}`)
+		}
+		return `{"function_name":"f","parameters":[` + strings.Join(parameters, ",") + `],"return_type":"` + returnType + `"}`
+	}
+	for _, tt := range []struct {
+		name      string
+		signature string
+		tests     string
+		want      string // Part of the only error, "" for none
+	}{
+		{"IO-based", "", "", ""},
+		{"valid", signature("List[int]", "nums:List[int]", "k:int"), `[{"input":{"nums":[1,2],"k":1},"output":[2]}]`, ""},
+		{"optional output", signature("Optional[int]", "s:str"), `[{"input":{"s":"a"},"output":null}]`, ""},
+		{"int keyed dict", signature("bool", "d:Dict[int, str]"), `[{"input":{"d":{"1":"a"}},"output":true}]`, ""},
+		{"bad signature JSON", `{"function_name":`, "", "signature_json"},
+		{"no function name", `{"parameters":[],"return_type":"int"}`, `[{"input":{},"output":1}]`, "function_name is required"},
+		{"unnamed parameter", signature("int", ":int"), `[{"input":{},"output":1}]`, "has no name"},
+		{"duplicate parameter", signature("int", "a:int", "a:str"), `[{"input":{"a":1},"output":1}]`, "duplicate parameter"},
+		{"unknown parameter type", signature("int", "a:integer"), `[{"input":{"a":1},"output":1}]`, `parameter "a"`},
+		{"unknown return type", signature("Tuple[int]", "a:int"), `[{"input":{"a":1},"output":1}]`, "return_type"},
+		{"no tests", signature("int", "a:int"), "", "at least one test case"},
+		{"empty test list", signature("int", "a:int"), "[]", "at least one test case"},
+		{"tests not a list", signature("int", "a:int"), `{"input":{"a":1}}`, "test_cases_json"},
+		{"missing input", signature("int", "a:int"), `[{"output":1}]`, `missing "input"`},
+		{"input not an object", signature("int", "a:int"), `[{"input":[1],"output":1}]`, "must be an object"},
+		{"missing argument", signature("int", "a:int", "b:int"), `[{"input":{"a":1},"output":1}]`, `missing input "b"`},
+		{"unexpected argument", signature("int", "a:int"), `[{"input":{"a":1,"c":2},"output":1}]`, `unexpected input "c"`},
+		{"argument of the wrong type", signature("int", "a:int"), `[{"input":{"a":1.5},"output":1}]`, "expected int, got float"},
+		{"nested element of the wrong type", signature("int", "m:List[List[int]]"), `[{"input":{"m":[[1],[2,"x"]]},"output":1}]`, "[1][1]: expected int, got str"},
+		{"missing output", signature("int", "a:int"), `[{"input":{"a":1}}]`, `missing "output"`},
+		{"output of the wrong type", signature("str", "a:int"), `[{"input":{"a":1},"output":1}]`, "output: expected str, got int"},
+		{"dict key not an int", signature("int", "d:Dict[int, int]"), `[{"input":{"d":{"x":1}},"output":1}]`, `dict key "x" is not an int`},
+	} {
+		errs := ValidateProblemSchema(Problem{SignatureJSON: tt.signature, TestCasesJSON: tt.tests})
+		switch {
+		case tt.want == "" && len(errs) != 0:
+			t.Errorf("%s: errors %q, want none", tt.name, errs)
+		case tt.want != "" && (len(errs) != 1 || !strings.Contains(errs[0], tt.want)):
+			t.Errorf("%s: errors %q, want one about %q", tt.name, errs, tt.want)
+		}
+	}
+}
+
+// A function-based problem can't be created without tests, but an update
+// that leaves them out keeps the ones it has
+func TestFunctionProblemsNeedTestsOnCreate(t *testing.T) {
+	s := newTestServer(t, NewMemoryStores())
+	useTestBlobs(t)
+	setter := s.signUp("alice", RoleSetter)
+
+	problem := gin.H{"title": "Add", "signature_json": testSignatureJSON}
+	s.decode(s.request(http.MethodPost, "/problem", setter, problem), http.StatusBadRequest, nil)
+	problem["test_cases_json"] = `[{"input":{"a":1,"b":2},"output":3}]`
+	var created struct{ ID uint }
+	s.decode(s.request(http.MethodPost, "/problem", setter, problem), http.StatusCreated, &created)
+
+	update := gin.H{"id": created.ID, "title": "Add", "signature_json": testSignatureJSON}
+	s.decode(s.request(http.MethodPut, "/problem", setter, update), http.StatusOK, nil)
+	update["test_cases_json"] = "[]"
+	s.decode(s.request(http.MethodPut, "/problem", setter, update), http.StatusBadRequest, nil)
+	var got Problem
+	s.decode(s.request(http.MethodGet, "/problem/"+strconv.Itoa(int(created.ID)), setter, nil), http.StatusOK, &got)
+	if got.TestCasesJSON == "" {
+		t.Error("the tests were dropped")
+	}
+}
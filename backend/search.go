@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	MaxProblemTags   = 10
+	MaxProblemTagLen = 32
+)
+
+// ProblemFilter selects problems. Zero fields match everything.
+type ProblemFilter struct {
+	Practice   bool     // Only problems outside any contest
+	ContestID  uint     // Only problems of this contest
+	Difficulty string   // Case-insensitive
+	Tags       []string // Normalized; a problem must have all of them
+	MinPoints  *int
+	MaxPoints  *int
+	SolvedBy   string // Only problems this user has passed
+	UnsolvedBy string // Only problems this user hasn't passed
+	Query      string // Words that must all appear in the title or statement
+}
+
+// normalizeTags lowercases, trims and sorts tags and drops empty and
+// repeated ones
+func normalizeTags(tags []string) []string {
+	if tags == nil {
+		return nil
+	}
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// searchTerms splits a text query into lowercase words
+func searchTerms(query string) []string {
+	return strings.Fields(strings.ToLower(query))
+}
+
+// ftsMatch turns a text query into an FTS5 MATCH expression: every word,
+// quoted so FTS5 operators in it are taken literally, as a prefix
+func ftsMatch(query string) string {
+	terms := searchTerms(query)
+	for i, term := range terms {
+		terms[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(terms, " ")
+}
+
+// parseProblemFilter reads the problem filters shared by problem lists:
+// difficulty, tags (comma separated, all must match), min_points,
+// max_points, solved (true or false, needs a login) and q (text search).
+// It answers the request if they are invalid.
+func (s *Server) parseProblemFilter(c *gin.Context, filter *ProblemFilter) bool {
+	filter.Difficulty = c.Query("difficulty")
+	filter.Query = c.Query("q")
+	if tags := c.Query("tags"); tags != "" {
+		filter.Tags = normalizeTags(strings.Split(tags, ","))
+	}
+	for param, field := range map[string]**int{"min_points": &filter.MinPoints, "max_points": &filter.MaxPoints} {
+		if value := c.Query(param); value != "" {
+			points, err := strconv.Atoi(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return false
+			}
+			*field = &points
+		}
+	}
+	if value := c.Query("solved"); value != "" {
+		solved, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "solved must be true or false"})
+			return false
+		}
+		user, ok := s.sessionUser(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Login required to filter by solved"})
+			return false
+		}
+		if solved {
+			filter.SolvedBy = user.Username
+		} else {
+			filter.UnsolvedBy = user.Username
+		}
+	}
+	return true
+}
+
+// handleGetPracticeProblems lists the practice archive a page at a time,
+// see parseListOptions, filtered as described on parseProblemFilter
+func (s *Server) handleGetPracticeProblems(c *gin.Context) {
+	opts, ok := parseListOptions(c, problemOrder, "id")
+	if !ok {
+		return
+	}
+	filter := ProblemFilter{Practice: true}
+	if !s.parseProblemFilter(c, &filter) {
+		return
+	}
+	problems, page, err := s.Problems.ListProblems(filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if problems == nil {
+		problems = []Problem{}
+	}
+	setPageHeaders(c, page)
+	c.JSON(http.StatusOK, problems)
+}
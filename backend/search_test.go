@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestPracticeProblemsArePaged(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		for i, title := range []string{"Echo", "Sum", "Contest only", "Sort"} {
+			problem := Problem{Title: title, Difficulty: "Easy", Points: 10 * (i + 1)}
+			if title == "Contest only" {
+				problem.ContestID = 1
+			}
+			if err := stores.Problems.CreateProblem(&problem, "alice"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		router := NewServer(stores).Router()
+
+		var titles []string
+		url := "/problems/practice?limit=2&sort=-points"
+		for pages := 0; url != ""; pages++ {
+			if pages == 3 {
+				t.Fatal("paging never ended")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET %s: %d %s", url, w.Code, w.Body)
+			}
+			if total := w.Header().Get("X-Total-Count"); total != "3" {
+				t.Errorf("X-Total-Count %q, want 3 practice problems", total)
+			}
+			var problems []Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &problems); err != nil {
+				t.Fatal(err)
+			}
+			for _, problem := range problems {
+				titles = append(titles, problem.Title)
+			}
+			url = ""
+			if cursor := w.Header().Get("X-Next-Cursor"); cursor != "" {
+				url = "/problems/practice?limit=2&sort=-points&cursor=" + cursor
+			}
+		}
+		if want := []string{"Sort", "Sum", "Echo"}; !slices.Equal(titles, want) {
+			t.Errorf("practice problems %q, want %q", titles, want)
+		}
+	})
+}
+
+func TestPracticeProblemsSolvedNeedsLogin(t *testing.T) {
+	router := NewServer(NewMemoryStores()).Router()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/problems/practice?solved=true", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401", w.Code)
+	}
+}
+
+func TestPracticeProblemsSearch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		s := newTestServer(t, stores)
+		for _, problem := range []Problem{
+			{Title: "Shortest Path", Description: "Find the cheapest route between two cities.", Tags: []string{"graphs"}},
+			{Title: "Spanning Tree", Description: "Connect every city by roads at the lowest cost.", Tags: []string{"dp", "graphs"}},
+			{Title: "Coin Change", Description: "Count the ways to pay an amount.", Tags: []string{"dp", "math"}},
+		} {
+			if err := stores.Problems.CreateProblem(&problem, "alice"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		search := func(query string) []string {
+			t.Helper()
+			var problems []Problem
+			s.decode(s.request(http.MethodGet, "/problems/practice?"+query, "", nil), http.StatusOK, &problems)
+			titles := []string{}
+			for _, problem := range problems {
+				titles = append(titles, problem.Title)
+			}
+			return titles
+		}
+
+		for _, tt := range []struct {
+			query string
+			want  []string
+		}{
+			{"q=path", []string{"Shortest Path"}},
+			{"q=SHORTEST+path", []string{"Shortest Path"}},
+			{"q=city+cost", []string{"Spanning Tree"}}, // Words of the statement
+			{"q=shortest+coin", []string{}},            // Every word must match
+			{"tags=graphs", []string{"Shortest Path", "Spanning Tree"}},
+			{"tags=Graphs,+DP", []string{"Spanning Tree"}}, // Normalized, all must match
+			{"tags=graphs&q=route", []string{"Shortest Path"}},
+			{"tags=geometry", []string{}},
+		} {
+			if got := search(tt.query); !slices.Equal(got, tt.want) {
+				t.Errorf("%s found %q, want %q", tt.query, got, tt.want)
+			}
+		}
+		// Search syntax in the query is taken as words, not as operators
+		search("q=" + url.QueryEscape(`path OR " NEAR(`))
+
+		// The index follows edits and deletions
+		problems, _, err := stores.Problems.ListProblems(ProblemFilter{Query: "coin"}, ListOptions{Sort: "id"})
+		if err != nil || len(problems) != 1 {
+			t.Fatalf("searching coin: %v, %v", problems, err)
+		}
+		problem, err := stores.Problems.GetProblemByID(problems[0].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		problem.Title = "Making Change"
+		if err := stores.Problems.UpdateProblem(problem, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		if got := search("q=coin"); len(got) != 0 {
+			t.Errorf("old title still found: %q", got)
+		}
+		if got := search("q=making"); !slices.Equal(got, []string{"Making Change"}) {
+			t.Errorf("new title found %q", got)
+		}
+		if err := stores.Problems.DeleteProblem(problem.ID); err != nil {
+			t.Fatal(err)
+		}
+		if got := search("q=making"); len(got) != 0 {
+			t.Errorf("deleted problem found: %q", got)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// Server owns the HTTP routes and the stores their handlers use. Handlers
+// that touch storage are its methods, so a Server built on NewMemoryStores
+// can be exercised without a database.
+type Server struct {
+	Stores
+}
+
+func NewServer(stores Stores) *Server {
+	return &Server{Stores: stores}
+}
+
+// trustedProxies reads TRUSTED_PROXIES, a comma-separated list of the IPs
+// or CIDRs of reverse proxies whose X-Forwarded-For is believed. None are
+// by default.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// Router returns the HTTP handler serving the API
+func (s *Server) Router() *gin.Engine {
+	router := gin.Default()
+	// Without this gin believes X-Forwarded-For from anyone, so the IPs in
+	// the audit log could be forged
+	if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+		panic("error: invalid TRUSTED_PROXIES: " + err.Error())
+	}
+
+	router.Use(cors.New(cors.Config{
+		AllowAllOrigins:  true,
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		ExposeHeaders:    []string{"Content-Length", "X-Next-Cursor", "X-Total-Count"},
+		AllowCredentials: true,
+		MaxAge:           12 * time.Hour,
+	}))
+
+	// Handlers check ownership themselves; these only gate by role
+	auth := s.AuthRequired()
+	adminOnly := RequireRole()
+	setters := RequireRole(RoleSetter, RoleContestManager)
+	managers := RequireRole(RoleContestManager)
+
+	router.GET("/", sayHello)
+	router.POST("/login", s.handleLogin)
+	router.POST("/logout", s.handleLogout)
+	router.POST("/logout/all", auth, s.handleLogoutAll)
+	router.GET("/me", auth, handleGetMe)
+	router.POST("/run", auth, s.handleRun)
+	router.POST("/execute", auth, handleExecute)
+	router.POST("/rejudge", auth, adminOnly, s.handleRejudge)
+	router.GET("/submission/:id", s.handleGetSubmission)
+	router.GET("/submission/:id/events", s.handleSubmissionStream)
+	router.POST("/submission/:id/cancel", auth, s.handleCancelSubmission)
+	router.GET("/languages", handleGetLanguages)
+	router.POST("/user/create", s.handleCreateUser)
+	router.POST("/user/exists", s.handleUserExists)
+	router.PUT("/user/:username/role", auth, adminOnly, s.handleSetUserRole)
+
+	router.POST("/contest", auth, managers, s.handleCreateContest)
+	router.PUT("/contest", auth, s.handleUpdateContest)
+	router.DELETE("/contest/:id", auth, s.handleDeleteContest)
+	router.GET("/contest/:id/staff", auth, s.handleGetContestStaff)
+	router.POST("/contest/:id/staff", auth, s.handleAddContestStaff)
+	router.DELETE("/contest/:id/staff/:username", auth, s.handleRemoveContestStaff)
+	router.GET("/contests", s.handleGetContests)
+	router.GET("/contest/:id", s.handleGetContest)
+	router.POST("/problem", auth, setters, s.handleCreateProblem)
+	router.POST("/problem/validate", auth, setters, handleValidateProblem)
+	router.GET("/problem/:id", s.handleGetProblem)
+	router.GET("/problem/:id/template", s.handleGetProblemTemplate)
+	router.POST("/problem/:id/tests", auth, s.handleUploadProblemTests)
+	router.GET("/problem/:id/tests", auth, s.handleDownloadProblemTests)
+	router.GET("/problem/:id/plagiarism", auth, s.handleGetProblemPlagiarism)
+	router.GET("/problem/:id/revisions", auth, s.handleGetProblemRevisions)
+	router.GET("/problem/:id/revisions/:rev", auth, s.handleGetProblemRevision)
+	router.GET("/problem/:id/revisions/:rev/diff", auth, s.handleDiffProblemRevisions)
+	router.POST("/problem/:id/revisions/:rev/rollback", auth, s.handleRollbackProblem)
+	router.GET("/problems/practice", s.handleGetPracticeProblems)
+	router.GET("/problems", s.handleGetAllProblems)
+	router.GET("/users", auth, adminOnly, s.handleGetAllUsers)
+	router.GET("/audit", auth, adminOnly, s.handleGetAuditLog)
+	router.PUT("/problem", auth, s.handleUpdateProblem)
+	router.DELETE("/problem/:id", auth, s.handleDeleteProblem)
+
+	router.POST("/contest/register", auth, s.handleRegisterContest)
+	router.GET("/contest/status", auth, s.handleGetRegistrationStatus)
+	router.GET("/contest/:id/registrations", auth, s.handleGetContestRegistrations)
+	router.GET("/leaderboard", s.handleGetLeaderboard)
+	router.GET("/contest/:id/leaderboard", s.handleGetContestLeaderboard)
+	router.GET("/contest/:id/leaderboard/stream", handleLeaderboardStream)
+	router.GET("/problem/:id/leaderboard", s.handleGetProblemLeaderboard)
+
+	return router
+}
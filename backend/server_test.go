@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testServer drives the API of a Server through its router
+type testServer struct {
+	t      *testing.T
+	stores Stores
+	router http.Handler
+}
+
+func newTestServer(t *testing.T, stores Stores) *testServer {
+	return &testServer{t: t, stores: stores, router: NewServer(stores).Router()}
+}
+
+// request sends body, if not nil, as JSON with token, if not empty, as the
+// session token
+func (s *testServer) request(method, url, token string, body any) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, url, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// decode reads a JSON response, failing the test unless it has status
+func (s *testServer) decode(w *httptest.ResponseRecorder, status int, v any) {
+	s.t.Helper()
+	if w.Code != status {
+		s.t.Fatalf("status %d, want %d: %s", w.Code, status, w.Body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+}
+
+// signUp registers a user with role and returns a session token for them
+func (s *testServer) signUp(username, role string) string {
+	s.t.Helper()
+	credentials := gin.H{"username": username, "email": username + "@example.com", "password": "secret-" + username}
+	s.decode(s.request(http.MethodPost, "/user/create", "", credentials), http.StatusCreated, nil)
+	if err := s.stores.Users.SetUserRole(username, role); err != nil {
+		s.t.Fatal(err)
+	}
+	var login struct{ Token string }
+	s.decode(s.request(http.MethodPost, "/login", "", credentials), http.StatusOK, &login)
+	return login.Token
+}
+
+func TestLoginAndLogout(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		s := newTestServer(t, stores)
+		token := s.signUp("alice", RoleContestant)
+
+		var me struct{ Username, Role string }
+		s.decode(s.request(http.MethodGet, "/me", token, nil), http.StatusOK, &me)
+		if me.Username != "alice" || me.Role != RoleContestant {
+			t.Errorf("GET /me is %+v", me)
+		}
+		s.decode(s.request(http.MethodPost, "/logout", token, nil), http.StatusOK, nil)
+		s.decode(s.request(http.MethodGet, "/me", token, nil), http.StatusUnauthorized, nil)
+
+		wrong := gin.H{"username": "alice", "password": "guess"}
+		s.decode(s.request(http.MethodPost, "/login", "", wrong), http.StatusUnauthorized, nil)
+	})
+}
+
+// Only those who may edit a problem see its tests and hidden code
+func TestGetProblemHidesTests(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		s := newTestServer(t, stores)
+		setter := s.signUp("alice", RoleSetter)
+		contestant := s.signUp("bob", RoleContestant)
+		admin := s.signUp("root", RoleAdmin)
+
+		problem := gin.H{"title": "Echo", "description": "Print the input", "input": "1", "output": "1", "runner_code": "secret"}
+		var created struct{ ID uint }
+		s.decode(s.request(http.MethodPost, "/problem", setter, problem), http.StatusCreated, &created)
+		url := "/problem/" + strconv.Itoa(int(created.ID))
+
+		for _, tt := range []struct {
+			who   string
+			token string
+			sees  bool
+		}{
+			{"anonymous", "", false},
+			{"a contestant", contestant, false},
+			{"the owner", setter, true},
+			{"an admin", admin, true},
+		} {
+			var got Problem
+			s.decode(s.request(http.MethodGet, url, tt.token, nil), http.StatusOK, &got)
+			if got.Title != "Echo" {
+				t.Errorf("%s got problem %q", tt.who, got.Title)
+			}
+			if sees := got.Input != "" || got.Output != "" || got.RunnerCode != ""; sees != tt.sees {
+				t.Errorf("%s sees tests: %v, want %v (%+v)", tt.who, sees, tt.sees, got)
+			}
+		}
+	})
+}
+
+func TestProblemPermissions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		s := newTestServer(t, stores)
+		setter := s.signUp("alice", RoleSetter)
+		other := s.signUp("carol", RoleSetter)
+		contestant := s.signUp("bob", RoleContestant)
+
+		problem := gin.H{"title": "Echo", "input": "1", "output": "1"}
+		s.decode(s.request(http.MethodPost, "/problem", contestant, problem), http.StatusForbidden, nil)
+		var created struct{ ID uint }
+		s.decode(s.request(http.MethodPost, "/problem", setter, problem), http.StatusCreated, &created)
+		url := "/problem/" + strconv.Itoa(int(created.ID))
+
+		update := gin.H{"id": created.ID, "title": "Mine now"}
+		s.decode(s.request(http.MethodPut, "/problem", other, update), http.StatusForbidden, nil)
+		s.decode(s.request(http.MethodDelete, url, other, nil), http.StatusForbidden, nil)
+		s.decode(s.request(http.MethodDelete, url, setter, nil), http.StatusOK, nil)
+		s.decode(s.request(http.MethodGet, url, setter, nil), http.StatusNotFound, nil)
+	})
+}
+
+func TestContestsNeedAManager(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		s := newTestServer(t, stores)
+		setter := s.signUp("alice", RoleSetter)
+		manager := s.signUp("dave", RoleContestManager)
+
+		contest := gin.H{"title": "Weekly"}
+		s.decode(s.request(http.MethodPost, "/contest", setter, contest), http.StatusForbidden, nil)
+		s.decode(s.request(http.MethodPost, "/contest", manager, contest), http.StatusCreated, nil)
+
+		var contests []Contest
+		s.decode(s.request(http.MethodGet, "/contests", "", nil), http.StatusOK, &contests)
+		if len(contests) != 1 || contests[0].Title != "Weekly" {
+			t.Errorf("contests %+v, want the one created", contests)
+		}
+	})
+}
+
+func TestForwardedForOnlyFromTrustedProxies(t *testing.T) {
+	clientIP := func(remoteAddr string) string {
+		router := NewServer(NewMemoryStores()).Router()
+		router.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	if ip := clientIP("198.51.100.1:4000"); ip != "198.51.100.1" {
+		t.Errorf("client IP %q with no trusted proxies, want the peer's", ip)
+	}
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.1")
+	if ip := clientIP("10.1.2.3:4000"); ip != "203.0.113.7" {
+		t.Errorf("client IP %q behind a trusted proxy, want the forwarded one", ip)
+	}
+	if ip := clientIP("198.51.100.1:4000"); ip != "198.51.100.1" {
+		t.Errorf("client IP %q from an untrusted peer, want the peer's", ip)
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		s := newTestServer(t, stores)
+		laptop := s.signUp("alice", RoleContestant)
+		var login struct{ Token string }
+		s.decode(s.request(http.MethodPost, "/login", "", gin.H{"username": "alice", "password": "secret-alice"}), http.StatusOK, &login)
+		phone := login.Token
+		other := s.signUp("bob", RoleContestant)
+
+		s.decode(s.request(http.MethodPost, "/logout/all", phone, nil), http.StatusOK, nil)
+		s.decode(s.request(http.MethodGet, "/me", laptop, nil), http.StatusUnauthorized, nil)
+		s.decode(s.request(http.MethodGet, "/me", phone, nil), http.StatusUnauthorized, nil)
+		s.decode(s.request(http.MethodGet, "/me", other, nil), http.StatusOK, nil)
+	})
+}
+
+// A contest manager moves problems only through PUT /problem, which checks
+// that they may edit them
+func TestContestUpdateDoesNotTakeProblems(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		s := newTestServer(t, stores)
+		setter := s.signUp("alice", RoleSetter)
+		manager := s.signUp("dave", RoleContestManager)
+
+		var contest, problem struct{ ID uint }
+		s.decode(s.request(http.MethodPost, "/contest", manager, gin.H{"title": "Weekly"}), http.StatusCreated, &contest)
+		s.decode(s.request(http.MethodPost, "/problem", setter, gin.H{"title": "Echo", "input": "1", "output": "1"}), http.StatusCreated, &problem)
+
+		update := gin.H{"id": contest.ID, "title": "Weekly", "problems": []gin.H{{"id": problem.ID, "title": "Echo"}}}
+		s.decode(s.request(http.MethodPut, "/contest", manager, update), http.StatusOK, nil)
+		got, err := stores.Problems.GetProblemByID(problem.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ContestID != 0 {
+			t.Errorf("alice's problem moved to contest %d by a contest update", got.ContestID)
+		}
+		s.decode(s.request(http.MethodPut, "/problem", manager, gin.H{"id": problem.ID, "title": "Echo", "contest_id": contest.ID}), http.StatusForbidden, nil)
+	})
+}
+
+// Lists are public, so they leave out what GET /problem/:id only shows to
+// those who may edit a problem
+func TestProblemListsHideCode(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		s := newTestServer(t, stores)
+		contest := Contest{Title: "Weekly"}
+		if err := stores.Contests.CreateContest(&contest); err != nil {
+			t.Fatal(err)
+		}
+		for _, contestID := range []uint{0, contest.ID} {
+			problem := Problem{Title: "Echo", ContestID: contestID, Validator: "SECRETVALIDATOR", RunnerCode: "SECRETRUNNER", TemplatesJSON: `{"python":"SECRETTEMPLATE"}`}
+			if err := stores.Problems.CreateProblem(&problem, "alice"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var listed, practice []Problem
+		var detail struct{ Problems []Problem }
+		s.decode(s.request(http.MethodGet, "/problems", "", nil), http.StatusOK, &listed)
+		s.decode(s.request(http.MethodGet, "/problems/practice", "", nil), http.StatusOK, &practice)
+		s.decode(s.request(http.MethodGet, "/contest/"+strconv.Itoa(int(contest.ID)), "", nil), http.StatusOK, &detail)
+		for name, problems := range map[string][]Problem{"/problems": listed, "/problems/practice": practice, "/contest/:id": detail.Problems} {
+			if len(problems) == 0 {
+				t.Errorf("%s listed no problems", name)
+			}
+			for _, problem := range problems {
+				if problem.Validator != "" || problem.RunnerCode != "" || problem.TemplatesJSON != "" {
+					t.Errorf("%s shows hidden code: %+v", name, problem)
+				}
+			}
+		}
+	})
+}
+
+func TestUpdateProblemMetadataKeepsTests(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		s := newTestServer(t, stores)
+		setter := s.signUp("alice", RoleSetter)
+
+		problem := gin.H{"title": "Add", "signature_json": testSignatureJSON, "test_cases_json": `[{"input":{"a":1,"b":2},"output":3}]`}
+		var created struct{ ID uint }
+		s.decode(s.request(http.MethodPost, "/problem", setter, problem), http.StatusCreated, &created)
+
+		update := gin.H{"id": created.ID, "title": "Add two numbers", "signature_json": testSignatureJSON}
+		s.decode(s.request(http.MethodPut, "/problem", setter, update), http.StatusOK, nil)
+		var got Problem
+		s.decode(s.request(http.MethodGet, "/problem/"+strconv.Itoa(int(created.ID)), setter, nil), http.StatusOK, &got)
+		if got.Title != "Add two numbers" || got.TestCasesJSON == "" {
+			t.Errorf("after a metadata update the problem is %q with tests %q", got.Title, got.TestCasesJSON)
+		}
+
+		// Tests kept from before must still fit the new signature
+		update["signature_json"] = `{"function_name":"neg","parameters":[{"name":"x","type":"int"}],"return_type":"int"}`
+		s.decode(s.request(http.MethodPut, "/problem", setter, update), http.StatusBadRequest, nil)
+	})
+}
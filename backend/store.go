@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrUserExists is returned by UserStore.CreateUser for a taken username
+var ErrUserExists = errors.New("username is already taken")
+
+// UserFilter selects users. Zero fields match everything.
+type UserFilter struct {
+	Role  string
+	Query string // Part of the username or email, case-insensitive
+}
+
+// ContestFilter selects contests. Zero fields match everything.
+type ContestFilter struct {
+	Status string // "upcoming", "active" or "ended", as of now
+}
+
+// How each list can be sorted and paged
+var (
+	userOrder = listOrder[User]{
+		Keys: []sortKey[User]{
+			{"username", "username", func(u User) any { return u.Username }},
+			{"email", "email", func(u User) any { return u.Email }},
+			{"role", "role", func(u User) any { return u.Role }},
+		},
+		Tiebreak: sortKey[User]{"username", "username", func(u User) any { return u.Username }},
+	}
+	contestOrder = listOrder[Contest]{
+		Keys: []sortKey[Contest]{
+			{"id", "id", func(c Contest) any { return c.ID }},
+			{"title", "title", func(c Contest) any { return c.Title }},
+			{"start_time", "start_time", func(c Contest) any { return c.StartTime }},
+			{"end_time", "end_time", func(c Contest) any { return c.EndTime }},
+		},
+		Tiebreak: sortKey[Contest]{"id", "id", func(c Contest) any { return c.ID }},
+	}
+	problemOrder = listOrder[Problem]{
+		Keys: []sortKey[Problem]{
+			{"id", "problems.id", func(p Problem) any { return p.ID }},
+			{"title", "problems.title", func(p Problem) any { return p.Title }},
+			{"difficulty", "problems.difficulty", func(p Problem) any { return p.Difficulty }},
+			{"points", "problems.points", func(p Problem) any { return p.Points }},
+		},
+		Tiebreak: sortKey[Problem]{"id", "problems.id", func(p Problem) any { return p.ID }},
+	}
+	registrationOrder = listOrder[Registration]{
+		Keys: []sortKey[Registration]{
+			{"id", "id", func(r Registration) any { return r.ID }},
+			{"user_id", "user_id", func(r Registration) any { return r.UserID }},
+			{"registered_at", "registered_at", func(r Registration) any { return r.RegisteredAt }},
+		},
+		Tiebreak: sortKey[Registration]{"id", "id", func(r Registration) any { return r.ID }},
+	}
+	leaderboardOrder = listOrder[LeaderboardEntry]{
+		Keys: []sortKey[LeaderboardEntry]{
+			{"score", "score", func(e LeaderboardEntry) any { return e.Score }},
+			{"username", "user_id", func(e LeaderboardEntry) any { return e.UserID }},
+		},
+		Tiebreak: sortKey[LeaderboardEntry]{"username", "user_id", func(e LeaderboardEntry) any { return e.UserID }},
+	}
+)
+
+// UserStore keeps accounts and their login sessions
+type UserStore interface {
+	CreateUser(user User) error
+	// Sortable by username, email or role, see userOrder
+	ListUsers(filter UserFilter, opts ListOptions) ([]User, Page, error)
+	GetUser(username string) (User, error)
+	UserExists(username string) (bool, error)
+	VerifyUser(username, password string) (bool, error)
+	SetUserRole(username, role string) error
+
+	CreateSession(session Session) error
+	GetSessionUser(tokenHash string) (User, error)
+	DeleteSession(tokenHash string) error
+	DeleteUserSessions(username string) error // Logs the user out everywhere
+	DeleteExpiredSessions() error
+}
+
+// ContestStore keeps contests and the staff who run them
+type ContestStore interface {
+	CreateContest(contest *Contest) error
+	UpdateContest(contest Contest) error
+	DeleteContest(id uint) error
+	// Sortable by id, title, start_time or end_time, see contestOrder
+	ListContests(filter ContestFilter, opts ListOptions) ([]Contest, Page, error)
+	GetContestByID(id uint) (Contest, error) // With its problems, as ListProblems returns them
+
+	AddContestStaff(contestID uint, username string) error
+	RemoveContestStaff(contestID uint, username string) error
+	GetContestStaff(contestID uint) ([]ContestStaff, error)
+	IsContestStaff(contestID uint, username string) (bool, error)
+}
+
+// ProblemStore keeps problems, their tags, test data and revisions. Only
+// GetProblemByID and GetProblemRevision fill in the tests, validator,
+// runner code and template overrides; lists leave them empty. Every
+// create, update and rollback records a new revision by author. A rollback
+// restores a revision's content, not the contest or owner it had then, and
+// deleting a problem keeps its revisions.
+type ProblemStore interface {
+	CreateProblem(problem *Problem, author string) error
+	GetProblemByID(id uint) (Problem, error)
+	// Sortable by id, title, difficulty or points, see problemOrder
+	ListProblems(filter ProblemFilter, opts ListOptions) ([]Problem, Page, error)
+	UpdateProblem(problem Problem, author string) error
+	DeleteProblem(id uint) error
+
+	GetProblemRevisions(problemID uint) ([]ProblemRevision, error) // Oldest first
+	GetProblemRevision(problemID uint, revision int) (Problem, error)
+	RollbackProblem(problemID uint, revision int, author string) error
+}
+
+// RegistrationStore keeps who registered for which contest
+type RegistrationStore interface {
+	RegisterForContest(userID string, contestID uint, extraInfo string) error
+	IsUserRegistered(userID string, contestID uint) (bool, error)
+	GetContestRegistrationsCount(contestID uint) (int64, error)
+	CountContestRegistrations(contestIDs []uint) (map[uint]int64, error)
+	// Sortable by id, user_id or registered_at, see registrationOrder
+	ListContestRegistrations(contestID uint, opts ListOptions) ([]Registration, Page, error)
+	GetRegisteredContests(userIDs []string) (map[string][]uint, error) // Contest IDs by user
+}
+
+// SubmissionStore keeps submissions, the verdict cache and the leaderboards
+// computed from them
+type SubmissionStore interface {
+	CreateSubmission(submission *Submission) error
+	UpdateSubmission(submission Submission) error
+	GetSubmissionByID(id uint) (Submission, error)
+	FindSubmissions(filter SubmissionFilter) ([]Submission, error)
+	GetAcceptedSubmissions(problemID uint) ([]Submission, error)
+
+	GetCachedVerdict(key string) (Verdict, bool, error)
+	SaveCachedVerdict(key string, problemID uint, verdict Verdict) error
+
+	// Sortable by score or username, see leaderboardOrder
+	GetLeaderboard(opts ListOptions) ([]LeaderboardEntry, Page, error)
+	GetContestLeaderboard(contestID uint) ([]LeaderboardEntry, error)
+	GetProblemLeaderboard(problemID uint) ([]ProblemLeaderboardEntry, error)
+}
+
+// AuditStore keeps the audit log of administrative changes
+type AuditStore interface {
+	RecordAuditEvent(event AuditEvent) error
+	FindAuditEvents(filter AuditFilter) ([]AuditEvent, error) // Newest first
+}
+
+// Stores is everything the server persists
+type Stores struct {
+	Users         UserStore
+	Contests      ContestStore
+	Problems      ProblemStore
+	Registrations RegistrationStore
+	Submissions   SubmissionStore
+	Audit         AuditStore
+}
+
+// OpenStores opens the stores selected by DB_DRIVER. "memory" keeps
+// everything in process and loses it on exit; any other driver is a
+// database, see openDatabase.
+func OpenStores() (Stores, error) {
+	if os.Getenv("DB_DRIVER") == "memory" {
+		return NewMemoryStores(), nil
+	}
+	db, err := openDatabase()
+	if err != nil {
+		return Stores{}, err
+	}
+	if err := MigrateUp(db); err != nil {
+		return Stores{}, fmt.Errorf("migrating database: %v", err)
+	}
+	return NewGormStores(db), nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// forEachStore runs test against every store implementation, each fresh
+// and with its own blob store
+func forEachStore(t *testing.T, test func(t *testing.T, stores Stores)) {
+	t.Run("memory", func(t *testing.T) {
+		useTestBlobs(t)
+		test(t, NewMemoryStores())
+	})
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		if err := MigrateUp(db); err != nil {
+			t.Fatal(err)
+		}
+		test(t, NewGormStores(db))
+	})
+}
+
+// forEachDatabase runs test against an empty database of every SQL
+// backend, with DB_DRIVER and DB_DSN pointing at it and its own blob store
+func forEachDatabase(t *testing.T, test func(t *testing.T, db *gorm.DB)) {
+	t.Run("sqlite", func(t *testing.T) {
+		useTestBlobs(t)
+		t.Setenv("DB_DRIVER", "sqlite")
+		t.Setenv("DB_DSN", filepath.Join(t.TempDir(), "test.db"))
+		test(t, openTestDatabase(t))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("TEST_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("set TEST_POSTGRES_DSN to test against PostgreSQL")
+		}
+		useTestBlobs(t)
+		t.Setenv("DB_DRIVER", "postgres")
+		t.Setenv("DB_DSN", postgresTestSchema(t, dsn))
+		test(t, openTestDatabase(t))
+	})
+}
+
+// postgresTestSchema creates an empty schema, dropped after the test, and
+// returns dsn with it as the search path so the test has a database of its
+// own
+func postgresTestSchema(t *testing.T, dsn string) string {
+	t.Helper()
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Skipf("PostgreSQL unavailable: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := sqlDB.Ping(); err != nil {
+		t.Skipf("PostgreSQL unavailable: %v", err)
+	}
+
+	schema := "codejudge_test_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := db.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	// Connection strings are either URLs or key=value pairs
+	if strings.Contains(dsn, "://") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		return dsn + separator + "search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
+
+// openTestDatabase opens the database DB_DRIVER and DB_DSN point at
+func openTestDatabase(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := openDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func useTestBlobs(t *testing.T) {
+	previous := Blobs
+	Blobs = &LocalBlobStore{Dir: t.TempDir()}
+	t.Cleanup(func() { Blobs = previous })
+}
+
+func TestSQLitePragmas(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_DSN", filepath.Join(t.TempDir(), "test.db"))
+	db, err := openDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	var journalMode string
+	var busyTimeout int
+	db.Raw("PRAGMA journal_mode").Scan(&journalMode)
+	db.Raw("PRAGMA busy_timeout").Scan(&busyTimeout)
+	if journalMode != "wal" || busyTimeout != 5000 {
+		t.Errorf("journal_mode %q, busy_timeout %d; want wal and 5000", journalMode, busyTimeout)
+	}
+}
+
+func TestRollbackKeepsContestAndOwner(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		problem := Problem{Title: "Echo", OwnerID: "alice", ContestID: 1}
+		if err := stores.Problems.CreateProblem(&problem, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		// An admin moves it to another contest and hands it to bob
+		problem.Title = "Echo twice"
+		problem.OwnerID = "bob"
+		problem.ContestID = 2
+		if err := stores.Problems.UpdateProblem(problem, "admin"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := stores.Problems.RollbackProblem(problem.ID, 1, "bob"); err != nil {
+			t.Fatal(err)
+		}
+		current, err := stores.Problems.GetProblemByID(problem.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if current.Title != "Echo" || current.Revision != 3 {
+			t.Errorf("problem %q at revision %d, want revision 1's content as revision 3", current.Title, current.Revision)
+		}
+		if current.ContestID != 2 || current.OwnerID != "bob" {
+			t.Errorf("rollback moved the problem to contest %d, owner %q", current.ContestID, current.OwnerID)
+		}
+	})
+}
+
+func TestDeleteProblemKeepsRevisions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		problem := Problem{Title: "Echo", OwnerID: "alice"}
+		if err := stores.Problems.CreateProblem(&problem, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		if err := stores.Problems.DeleteProblem(problem.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stores.Problems.GetProblemByID(problem.ID); err == nil {
+			t.Error("deleted problem is still there")
+		}
+		revisions, err := stores.Problems.GetProblemRevisions(problem.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 1 {
+			t.Errorf("%d revisions after deleting, want the history kept", len(revisions))
+		}
+	})
+}
+
+// sessionCount counts the stored sessions, expired or not
+func sessionCount(t *testing.T, stores Stores) int {
+	switch store := stores.Users.(type) {
+	case *MemoryStore:
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.sessions)
+	case *GormStore:
+		var count int64
+		if err := store.db.Model(&Session{}).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		return int(count)
+	}
+	t.Fatalf("unknown store %T", stores.Users)
+	return 0
+}
+
+func TestDeleteExpiredSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		if err := stores.Users.CreateUser(User{Username: "alice"}); err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		for hash, expires := range map[string]time.Time{"old": now.Add(-time.Hour), "current": now.Add(time.Hour)} {
+			if err := stores.Users.CreateSession(Session{TokenHash: hash, Username: "alice", CreatedAt: now, ExpiresAt: expires}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := stores.Users.DeleteExpiredSessions(); err != nil {
+			t.Fatal(err)
+		}
+		if n := sessionCount(t, stores); n != 1 {
+			t.Errorf("%d sessions left, want only the current one", n)
+		}
+		if _, err := stores.Users.GetSessionUser("current"); err != nil {
+			t.Errorf("current session was deleted: %v", err)
+		}
+	})
+}
+
+// Passwords stored before hashing still log in, and are hashed when they do
+func TestPlaintextPasswordsAreUpgraded(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores Stores) {
+		// The second merely looks like a bcrypt hash
+		for _, password := range []string{"hunter2", "$2a$10$hunter2"} {
+			if err := stores.Users.CreateUser(User{Username: password, Password: password}); err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := stores.Users.VerifyUser(password, "wrong"); ok || err != nil {
+				t.Errorf("%q: wrong password verified: %v, %v", password, ok, err)
+			}
+			for range 2 {
+				if ok, err := stores.Users.VerifyUser(password, password); !ok || err != nil {
+					t.Errorf("%q: password not verified: %v, %v", password, ok, err)
+				}
+				user, err := stores.Users.GetUser(password)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !isPasswordHash(user.Password) || !CheckPassword(user.Password, password) {
+					t.Errorf("%q: stored password %q is not its hash", password, user.Password)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// templateGenerators render starter code for a function-based problem,
+// keyed by language ID. Only the registry decides which languages are
+// offered; see TemplateLanguages.
+var templateGenerators = map[string]func(ProblemSignature) (string, error){
+	"python":     pythonTemplate,
+	"cpp":        cppTemplate,
+	"java":       javaTemplate,
+	"javascript": javascriptTemplate,
+}
+
+// TemplateLanguages lists the IDs of the registry languages starter code
+// can be generated for: those that can run function-based problems
+func TemplateLanguages() []string {
+	var langs []string
+	for _, lang := range Languages {
+		if _, ok := templateGenerators[lang.ID]; ok && lang.SupportsFunctions() {
+			langs = append(langs, lang.ID)
+		}
+	}
+	return langs
+}
+
+// ProblemTemplate returns the starter code for a problem in the given language.
+// A setter override in TemplatesJSON wins; otherwise function-based problems
+// get code generated from their signature, and IO-based problems fall back to
+// the hand-written Template (Python only).
+func ProblemTemplate(problem Problem, lang string) (string, error) {
+	if _, err := GetLanguage(lang); err != nil {
+		return "", err
+	}
+
+	overrides, err := parseTemplateOverrides(problem.TemplatesJSON)
+	if err != nil {
+		return "", err
+	}
+	if code, ok := overrides[lang]; ok {
+		return code, nil
+	}
+
+	if problem.SignatureJSON == "" {
+		if lang == "python" {
+			return problem.Template, nil
+		}
+		return "", fmt.Errorf("no %s template for this problem", lang)
+	}
+
+	var signature ProblemSignature
+	if err := json.Unmarshal([]byte(problem.SignatureJSON), &signature); err != nil {
+		return "", fmt.Errorf("invalid problem signature: %v", err)
+	}
+	return GenerateTemplate(signature, lang)
+}
+
+// GenerateTemplate renders starter code for a signature in one language
+func GenerateTemplate(signature ProblemSignature, lang string) (string, error) {
+	language, err := GetLanguage(lang)
+	if err != nil {
+		return "", err
+	}
+	if !language.SupportsFunctions() {
+		return "", fmt.Errorf("%s can't be used for function-based problems", language.Name)
+	}
+	generate, ok := templateGenerators[lang]
+	if !ok {
+		return "", fmt.Errorf("no starter code generator for %s", language.Name)
+	}
+	return generate(signature)
+}
+
+func parseTemplateOverrides(templatesJSON string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	if strings.TrimSpace(templatesJSON) == "" {
+		return overrides, nil
+	}
+	if err := json.Unmarshal([]byte(templatesJSON), &overrides); err != nil {
+		return nil, fmt.Errorf("invalid templates_json: %v", err)
+	}
+	return overrides, nil
+}
+
+// signatureTypes parses the parameter and return types of a signature
+func signatureTypes(signature ProblemSignature) ([]TypeSpec, TypeSpec, error) {
+	params := make([]TypeSpec, 0, len(signature.Parameters))
+	for _, param := range signature.Parameters {
+		t, err := ParseType(param.Type)
+		if err != nil {
+			return nil, TypeSpec{}, fmt.Errorf("parameter %q: %v", param.Name, err)
+		}
+		params = append(params, t)
+	}
+	ret, err := ParseType(signature.ReturnType)
+	if err != nil {
+		return nil, TypeSpec{}, fmt.Errorf("return_type: %v", err)
+	}
+	return params, ret, nil
+}
+
+func className(signature ProblemSignature) string {
+	if signature.ClassName == "" {
+		return "Solution"
+	}
+	return signature.ClassName
+}
+
+// === Python ===
+
+func pythonType(t TypeSpec, imports map[string]bool) string {
+	switch t.Name {
+	case "list":
+		imports["List"] = true
+		return "List[" + pythonType(t.Args[0], imports) + "]"
+	case "dict":
+		imports["Dict"] = true
+		return "Dict[" + pythonType(t.Args[0], imports) + ", " + pythonType(t.Args[1], imports) + "]"
+	case "optional":
+		imports["Optional"] = true
+		return "Optional[" + pythonType(t.Args[0], imports) + "]"
+	}
+	return t.Name
+}
+
+func pythonTemplate(signature ProblemSignature) (string, error) {
+	params, ret, err := signatureTypes(signature)
+	if err != nil {
+		return "", err
+	}
+
+	imports := make(map[string]bool)
+	args := []string{"self"}
+	for i, param := range signature.Parameters {
+		args = append(args, param.Name+": "+pythonType(params[i], imports))
+	}
+	retType := pythonType(ret, imports)
+
+	var b strings.Builder
+	if len(imports) > 0 {
+		var names []string
+		for name := range imports {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(&b, "from typing import %s\n\n\n", strings.Join(names, ", "))
+	}
+	fmt.Fprintf(&b, "class %s:\n", className(signature))
+	fmt.Fprintf(&b, "    def %s(%s) -> %s:\n", signature.FunctionName, strings.Join(args, ", "), retType)
+	b.WriteString("        pass\n")
+	return b.String(), nil
+}
+
+// === C++ ===
+
+func cppType(t TypeSpec) string {
+	switch t.Name {
+	case "float":
+		return "double"
+	case "str":
+		return "string"
+	case "list":
+		return "vector<" + cppType(t.Args[0]) + ">"
+	case "dict":
+		return "unordered_map<" + cppType(t.Args[0]) + ", " + cppType(t.Args[1]) + ">"
+	case "optional":
+		return "optional<" + cppType(t.Args[0]) + ">"
+	}
+	return t.Name
+}
+
+func cppTemplate(signature ProblemSignature) (string, error) {
+	params, ret, err := signatureTypes(signature)
+	if err != nil {
+		return "", err
+	}
+
+	var args []string
+	for i, param := range signature.Parameters {
+		argType := cppType(params[i])
+		// Containers and strings are passed by reference, scalars by value
+		if params[i].Name != "int" && params[i].Name != "float" && params[i].Name != "bool" {
+			argType += "&"
+		}
+		args = append(args, argType+" "+param.Name)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "class %s {\n", className(signature))
+	b.WriteString("public:\n")
+	fmt.Fprintf(&b, "    %s %s(%s) {\n", cppType(ret), signature.FunctionName, strings.Join(args, ", "))
+	b.WriteString("        \n")
+	b.WriteString("    }\n")
+	b.WriteString("};\n")
+	return b.String(), nil
+}
+
+// === Java ===
+
+// javaArrayable reports whether a type maps onto a plain Java array element
+func javaArrayable(t TypeSpec) bool {
+	switch t.Name {
+	case "int", "float", "str", "bool":
+		return true
+	case "list":
+		return javaArrayable(t.Args[0])
+	}
+	return false
+}
+
+func javaType(t TypeSpec, boxed bool) string {
+	switch t.Name {
+	case "int":
+		if boxed {
+			return "Integer"
+		}
+		return "int"
+	case "float":
+		if boxed {
+			return "Double"
+		}
+		return "double"
+	case "bool":
+		if boxed {
+			return "Boolean"
+		}
+		return "boolean"
+	case "str":
+		return "String"
+	case "list":
+		// Lists of scalars become arrays (int[], int[][]), anything holding
+		// maps or optionals becomes a generic List
+		if javaArrayable(t.Args[0]) && !boxed {
+			return javaType(t.Args[0], false) + "[]"
+		}
+		return "List<" + javaType(t.Args[0], true) + ">"
+	case "dict":
+		return "Map<" + javaType(t.Args[0], true) + ", " + javaType(t.Args[1], true) + ">"
+	case "optional":
+		return javaType(t.Args[0], true)
+	}
+	return t.Name
+}
+
+func javaTemplate(signature ProblemSignature) (string, error) {
+	params, ret, err := signatureTypes(signature)
+	if err != nil {
+		return "", err
+	}
+
+	var args []string
+	for i, param := range signature.Parameters {
+		args = append(args, javaType(params[i], false)+" "+param.Name)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "class %s {\n", className(signature))
+	fmt.Fprintf(&b, "    public %s %s(%s) {\n", javaType(ret, false), signature.FunctionName, strings.Join(args, ", "))
+	b.WriteString("        \n")
+	b.WriteString("    }\n")
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+// === JavaScript ===
+
+func jsDocType(t TypeSpec) string {
+	switch t.Name {
+	case "int", "float":
+		return "number"
+	case "str":
+		return "string"
+	case "bool":
+		return "boolean"
+	case "list":
+		return jsDocType(t.Args[0]) + "[]"
+	case "dict":
+		return "Object<" + jsDocType(t.Args[0]) + ", " + jsDocType(t.Args[1]) + ">"
+	case "optional":
+		return "?" + jsDocType(t.Args[0])
+	}
+	return "*"
+}
+
+func javascriptTemplate(signature ProblemSignature) (string, error) {
+	params, ret, err := signatureTypes(signature)
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	var b strings.Builder
+	fmt.Fprintf(&b, "class %s {\n", className(signature))
+	b.WriteString("    /**\n")
+	for i, param := range signature.Parameters {
+		fmt.Fprintf(&b, "     * @param {%s} %s\n", jsDocType(params[i]), param.Name)
+		names = append(names, param.Name)
+	}
+	fmt.Fprintf(&b, "     * @return {%s}\n", jsDocType(ret))
+	b.WriteString("     */\n")
+	fmt.Fprintf(&b, "    %s(%s) {\n", signature.FunctionName, strings.Join(names, ", "))
+	b.WriteString("        \n")
+	b.WriteString("    }\n")
+	b.WriteString("}\n")
+	return b.String(), nil
+}
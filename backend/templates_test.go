@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// useLanguages swaps the language registry for the duration of a test
+func useLanguages(t *testing.T, languages []Language) {
+	previous := Languages
+	Languages = languages
+	t.Cleanup(func() { Languages = previous })
+}
+
+var testSignatureJSON = `{"function_name":"add","parameters":[{"name":"a","type":"int"},{"name":"b","type":"int"}],"return_type":"int"}`
+
+func TestTemplatesFollowTheRegistry(t *testing.T) {
+	useLanguages(t, []Language{
+		{ID: "python", Name: "Python", Harness: "runner/harness.py"},
+		{ID: "cpp", Name: "C++"},                                 // Has a generator but no harness
+		{ID: "ruby", Name: "Ruby", Harness: "runner/harness.rb"}, // Has a harness but no generator
+	})
+
+	if got := TemplateLanguages(); !slices.Equal(got, []string{"python"}) {
+		t.Errorf("template languages %v, want only python", got)
+	}
+
+	problem := Problem{SignatureJSON: testSignatureJSON}
+	code, err := ProblemTemplate(problem, "python")
+	if err != nil || !strings.Contains(code, "def add(self, a: int, b: int) -> int:") {
+		t.Errorf("python template %q, error %v", code, err)
+	}
+	for _, lang := range []string{"cpp", "ruby", "java"} {
+		if _, err := ProblemTemplate(problem, lang); err == nil {
+			t.Errorf("%s template generated for a function-based problem", lang)
+		}
+	}
+
+	// IO-based problems can be solved in any registry language, so
+	// overrides for them may name one without a harness
+	problem = Problem{TemplatesJSON: `{"cpp":"int main() {}"}`}
+	if code, err := ProblemTemplate(problem, "cpp"); err != nil || code != "int main() {}" {
+		t.Errorf("cpp override %q, error %v", code, err)
+	}
+	if errs := ValidateProblemSchema(problem); len(errs) != 0 {
+		t.Errorf("IO-based problem with a cpp override rejected: %v", errs)
+	}
+	problem.SignatureJSON = testSignatureJSON
+	problem.TestCasesJSON = `[{"input":{"a":1,"b":2},"output":3}]`
+	if errs := ValidateProblemSchema(problem); len(errs) != 1 || !strings.Contains(errs[0], "templates_json") {
+		t.Errorf("function-based problem with a cpp override: errors %v, want the override rejected", errs)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	MaxTestArchiveSize = 64 << 20  // Largest zip accepted by POST /problem/:id/tests
+	MaxTestDataSize    = 256 << 20 // Largest total of the unpacked test files
+)
+
+// IOTest is one stdin/expected-stdout pair of an IO-based problem. Problems
+// with several tests store them as a JSON list in TestCasesJSON; older
+// problems have a single test in Input and Output.
+type IOTest struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+}
+
+// ioTests returns the tests of an IO-based problem
+func ioTests(problem Problem) ([]IOTest, error) {
+	if strings.TrimSpace(problem.TestCasesJSON) == "" {
+		return []IOTest{{Input: problem.Input, Output: problem.Output}}, nil
+	}
+	var tests []IOTest
+	if err := json.Unmarshal([]byte(problem.TestCasesJSON), &tests); err != nil {
+		return nil, fmt.Errorf("invalid test cases: %v", err)
+	}
+	return tests, nil
+}
+
+// ArchiveTest is one input/answer pair read from a test archive
+type ArchiveTest struct {
+	Name   string // Path of the input file without its extension
+	Input  string
+	Output string
+}
+
+// answerExtensions are the extensions of expected output files: .out for
+// 1.in/1.out archives, .ans for Kattis and .a for Polygon
+var answerExtensions = map[string]bool{".out": true, ".ans": true, ".a": true}
+
+// ReadTestArchive unpacks a zip of test files. Inputs end in .in, or have
+// no extension as in Polygon's tests/01 + tests/01.a, and are paired with
+// the answer file of the same name. Files in any folder are accepted so
+// Kattis' data/sample and data/secret work as is. Tests are returned in
+// natural order of their names (2 before 10).
+func ReadTestArchive(data []byte) ([]ArchiveTest, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %v", err)
+	}
+
+	inputs := make(map[string]string)
+	outputs := make(map[string]string)
+	var bare []string // Extensionless files, inputs only if they have an answer
+	remaining := int64(MaxTestDataSize)
+	for _, file := range reader.File {
+		name := file.Name
+		if file.FileInfo().IsDir() || strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".") {
+			continue
+		}
+		ext := path.Ext(name)
+		if ext != ".in" && ext != "" && !answerExtensions[ext] {
+			continue // problem.yaml, statements, checkers...
+		}
+
+		content, err := readZipFile(file, remaining)
+		if err != nil {
+			return nil, err
+		}
+		remaining -= int64(len(content))
+
+		base := strings.TrimSuffix(name, ext)
+		switch {
+		case ext == ".in":
+			inputs[base] = content
+		case ext == "":
+			inputs[base] = content
+			bare = append(bare, base)
+		default:
+			if _, dup := outputs[base]; dup {
+				return nil, fmt.Errorf("%s: test has more than one answer file", base)
+			}
+			outputs[base] = content
+		}
+	}
+	for _, base := range bare {
+		if _, ok := outputs[base]; !ok {
+			delete(inputs, base)
+		}
+	}
+
+	var tests []ArchiveTest
+	for base, input := range inputs {
+		output, ok := outputs[base]
+		if !ok {
+			return nil, fmt.Errorf("%s: input has no answer file", base)
+		}
+		tests = append(tests, ArchiveTest{Name: base, Input: input, Output: output})
+		delete(outputs, base)
+	}
+	for base := range outputs {
+		return nil, fmt.Errorf("%s: answer has no input file", base)
+	}
+	if len(tests) == 0 {
+		return nil, fmt.Errorf("archive contains no tests")
+	}
+	sort.Slice(tests, func(i, j int) bool { return naturalLess(tests[i].Name, tests[j].Name) })
+	return tests, nil
+}
+
+func readZipFile(file *zip.File, limit int64) (string, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", file.Name, err)
+	}
+	defer rc.Close()
+	// Don't trust the sizes in the zip header
+	content, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", file.Name, err)
+	}
+	if int64(len(content)) > limit {
+		return "", fmt.Errorf("test data is larger than %d MB", MaxTestDataSize>>20)
+	}
+	return string(content), nil
+}
+
+// naturalLess compares strings with runs of digits ordered by value
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		na, nb := leadingDigits(a), leadingDigits(b)
+		if na > 0 && nb > 0 {
+			x, _ := strconv.ParseUint(a[:na], 10, 64)
+			y, _ := strconv.ParseUint(b[:nb], 10, 64)
+			if x != y {
+				return x < y
+			}
+			a, b = a[na:], b[nb:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingDigits(s string) int {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return n
+}
+
+// problemTestFiles returns a problem's tests as input/expected output
+// text. For function-based problems the input is the JSON object of
+// arguments and the output the JSON return value.
+func problemTestFiles(problem Problem) ([]IOTest, error) {
+	if problem.SignatureJSON == "" {
+		return ioTests(problem)
+	}
+	if strings.TrimSpace(problem.TestCasesJSON) == "" {
+		return nil, nil
+	}
+	var testCases []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(problem.TestCasesJSON), &testCases); err != nil {
+		return nil, fmt.Errorf("invalid test cases: %v", err)
+	}
+	tests := make([]IOTest, len(testCases))
+	for i, tc := range testCases {
+		tests[i] = IOTest{Input: string(tc["input"]), Output: string(tc["output"])}
+	}
+	return tests, nil
+}
+
+// setProblemTestFiles is the reverse of problemTestFiles. An IO-based
+// problem with a single test keeps it in Input and Output.
+func setProblemTestFiles(problem *Problem, tests []IOTest) error {
+	problem.Input, problem.Output, problem.TestCasesJSON = "", "", ""
+	if len(tests) == 0 {
+		return nil
+	}
+	if problem.SignatureJSON == "" {
+		if len(tests) == 1 {
+			problem.Input, problem.Output = tests[0].Input, tests[0].Output
+			return nil
+		}
+		data, err := json.Marshal(tests)
+		if err != nil {
+			return err
+		}
+		problem.TestCasesJSON = string(data)
+		return nil
+	}
+
+	testCases := make([]map[string]json.RawMessage, len(tests))
+	for i, test := range tests {
+		testCases[i] = map[string]json.RawMessage{
+			"input":  json.RawMessage(test.Input),
+			"output": json.RawMessage(test.Output),
+		}
+	}
+	data, err := json.Marshal(testCases)
+	if err != nil {
+		return fmt.Errorf("invalid test cases: %v", err)
+	}
+	problem.TestCasesJSON = string(data)
+	return nil
+}
+
+// hasTestData reports whether a problem carries any tests
+func hasTestData(problem Problem) bool {
+	return problem.Input != "" || problem.Output != "" || strings.TrimSpace(problem.TestCasesJSON) != ""
+}
+
+// SetProblemTests replaces a problem's tests with the ones from an archive.
+// For function-based problems each input file holds the JSON object of
+// arguments and each answer file the JSON return value.
+func SetProblemTests(problem *Problem, tests []ArchiveTest) error {
+	files := make([]IOTest, len(tests))
+	for i, test := range tests {
+		files[i] = IOTest{Input: test.Input, Output: test.Output}
+		if problem.SignatureJSON == "" {
+			continue
+		}
+		// Compact so the stored JSON doesn't depend on the archive's formatting
+		var input, output bytes.Buffer
+		if err := json.Compact(&input, []byte(test.Input)); err != nil {
+			return fmt.Errorf("%s: input is not valid JSON: %v", test.Name, err)
+		}
+		if err := json.Compact(&output, []byte(test.Output)); err != nil {
+			return fmt.Errorf("%s: answer is not valid JSON: %v", test.Name, err)
+		}
+		files[i] = IOTest{Input: input.String(), Output: output.String()}
+	}
+	return setProblemTestFiles(problem, files)
+}
+
+// WriteTestArchive writes a problem's tests as a zip of 1.in/1.out, 2.in/2.out...
+func WriteTestArchive(w io.Writer, problem Problem) error {
+	tests, err := problemTestFiles(problem)
+	if err != nil {
+		return err
+	}
+	if problem.SignatureJSON != "" {
+		for i := range tests {
+			tests[i].Input += "\n"
+			tests[i].Output += "\n"
+		}
+	}
+
+	archive := zip.NewWriter(w)
+	for i, test := range tests {
+		for _, file := range []struct{ name, content string }{
+			{fmt.Sprintf("%d.in", i+1), test.Input},
+			{fmt.Sprintf("%d.out", i+1), test.Output},
+		} {
+			fw, err := archive.Create(file.name)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(fw, file.content); err != nil {
+				return err
+			}
+		}
+	}
+	return archive.Close()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// zeros reads as an endless run of zero bytes
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// uploadTests posts archive as the test archive of a problem
+func (s *testServer) uploadTests(problemID uint, token string, archive io.Reader) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var head bytes.Buffer
+	form := multipart.NewWriter(&head)
+	if _, err := form.CreateFormFile("file", "tests.zip"); err != nil {
+		s.t.Fatal(err)
+	}
+	tail := "\r\n--" + form.Boundary() + "--\r\n"
+	body := io.MultiReader(&head, archive, bytes.NewReader([]byte(tail)))
+
+	req := httptest.NewRequest(http.MethodPost, "/problem/"+strconv.Itoa(int(problemID))+"/tests", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUploadProblemTests(t *testing.T) {
+	s := newTestServer(t, NewMemoryStores())
+	useTestBlobs(t)
+	setter := s.signUp("alice", RoleSetter)
+	var created struct{ ID uint }
+	s.decode(s.request(http.MethodPost, "/problem", setter, gin.H{"title": "Echo", "input": "1", "output": "1"}), http.StatusCreated, &created)
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, content := range map[string]string{"1.in": "2\n", "1.out": "2\n", "2.in": "3\n", "2.out": "3\n"} {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(f, content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s.decode(s.uploadTests(created.ID, setter, &archive), http.StatusOK, nil)
+
+	// The body is cut off at the limit rather than read, and spooled, whole
+	size := int64(MaxTestArchiveSize + 8<<20)
+	tooLarge := &countingReader{r: io.LimitReader(zeros{}, size)}
+	s.decode(s.uploadTests(created.ID, setter, tooLarge), http.StatusRequestEntityTooLarge, nil)
+	if tooLarge.n == size {
+		t.Error("the whole oversized archive was read")
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
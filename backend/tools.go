@@ -1,20 +1,19 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"time"
 )
 
 const WORKSPACE = "workspace"
 const RUNNER = "runner"
 
-func makeRunDirectory(username string) (string, error) {
-	runPath := filepath.Join(WORKSPACE, username)
+func makeRunDirectory(runID string) (string, error) {
+	runPath := filepath.Join(WORKSPACE, runID)
 	if err := os.MkdirAll(runPath, 0755); err != nil {
 		return "", err
 	}
@@ -22,7 +21,11 @@ func makeRunDirectory(username string) (string, error) {
 }
 
 func createFileFromText(dest, filename, text string) error {
-	file, err := os.Create(filepath.Join(dest, filename))
+	// The compile step runs as the sandbox user in the run directory, so
+	// replace rather than write through whatever it may have left there
+	path := filepath.Join(dest, filename)
+	os.Remove(path)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
 		return err
 	}
@@ -32,83 +35,106 @@ func createFileFromText(dest, filename, text string) error {
 	return err
 }
 
-func hydrateRunDirectory(username, problemIDStr, solution string) error {
-	path, err := makeRunDirectory(username)
+func hydrateRunDirectory(runID string, problem Problem, lang Language, solution string) error {
+	path, err := makeRunDirectory(runID)
 	if err != nil {
 		return err
 	}
 
-	// Fetch problem from DB
-	problemID, err := strconv.Atoi(problemIDStr)
-	if err != nil {
-		return fmt.Errorf("invalid problem id: %v", err)
-	}
-
-	problem, err := GetProblemByID(uint(problemID))
-
-	if err != nil {
-
-		return fmt.Errorf("problem not found: %v", err)
-
-	}
-
 	fullSolution := solution
 
-	if problem.RunnerCode != "" {
+	// Runner code is written in Python, it can't be appended to other languages
+	if problem.RunnerCode != "" && lang.ID == "python" {
 
 		fullSolution = solution + "\n\n" + problem.RunnerCode
 
 	}
 
-	createFileFromText(path, "solution.py", fullSolution)
+	return createFileFromText(path, lang.SourceFile, fullSolution)
+}
+
+func hydrateTestCase(runID string, test IOTest) error {
+	path := filepath.Join(WORKSPACE, runID)
 
-	createFileFromText(path, "input.txt", problem.Input)
+	createFileFromText(path, "input.txt", test.Input)
 
-	createFileFromText(path, "expected.txt", problem.Output)
+	createFileFromText(path, "expected.txt", test.Output)
 
 	createFileFromText(path, "output.txt", "")
 
 	return nil
 }
 
-func runInContainer(username string) (string, error) {
-	workDir, err := filepath.Abs(filepath.Join(WORKSPACE, username))
+// compileInContainer runs the language's compile step once for a whole
+// submission, with its own limits. The build is left in the run directory
+// for every test to run. A *CompileError means the source didn't compile.
+func compileInContainer(ctx context.Context, runPath string, lang Language) error {
+	if lang.CompileCommand == "" {
+		return nil
+	}
+	outcome, err := Sandbox.Run(ctx, RunSpec{
+		WorkDir:  runPath,
+		Command:  lang.CompileCommand,
+		Image:    lang.Image,
+		RootFS:   lang.RootFS,
+		Limits:   CompileLimits,
+		Writable: true,
+	})
+	if err != nil {
+		return err
+	}
+	if outcome.TimedOut {
+		return &CompileError{Output: "Compilation took longer than " + CompileLimits.WallTime.String()}
+	}
+	if outcome.ExitCode != 0 || outcome.OutputExceeded {
+		return &CompileError{Output: outcome.Stderr + outcome.Stdout}
+	}
+	return nil
+}
+
+func runInContainer(ctx context.Context, runID string, lang Language, limits Limits) (string, error) {
+	limits.WallTime = 2 * time.Second
+	limits.CPUTime = 2 * time.Second
+
+	outcome, err := Sandbox.Run(ctx, RunSpec{
+		WorkDir: filepath.Join(WORKSPACE, runID),
+		Command: lang.RunCommand + " < input.txt",
+		Image:   lang.Image,
+		RootFS:  lang.RootFS,
+		Limits:  lang.Limits(limits),
+	})
 	if err != nil {
 		return "", err
 	}
-	cmd := exec.Command("docker", "run",
-		"--rm",
-		"--cpus=0.5",
-		"--memory=128m",
-		"-v", workDir+":/code",
-		"-w", "/code",
-		"python:3.11",
-		"sh", "-c", "timeout 2s python solution.py < input.txt > output.txt",
-	)
-	cmd.Dir = workDir
-
-	var stderr bytes.Buffer
-	var out bytes.Buffer
-
-	cmd.Stderr = &stderr
-	cmd.Stdout = &out
-
-	if err := cmd.Run(); err != nil {
-		if strings.Contains(err.Error(), "124") {
-			return "Time Limit Exceeded", fmt.Errorf("Time Limit Exceeded")
+	if outcome.OutputExceeded {
+		// Keep what was captured so the user can see what ran away
+		createFileFromText(filepath.Join(WORKSPACE, runID), "output.txt", outcome.Stdout)
+		return "Output Limit Exceeded", fmt.Errorf("Output Limit Exceeded")
+	}
+	if outcome.TimedOut {
+		return "Time Limit Exceeded", fmt.Errorf("Time Limit Exceeded")
+	}
+	if outcome.ExitCode != 0 {
+		if status := classifyViolation(outcome.Violations); status != "" {
+			return outcome.Stderr, &ViolationError{Status: status, Output: outcome.Stderr}
 		}
-		return stderr.String(), err
+		return outcome.Stderr, fmt.Errorf("exit status %d", outcome.ExitCode)
+	}
+	// The workspace is mounted read-only, so stdout is captured here rather
+	// than redirected to output.txt inside the sandbox
+	if err := createFileFromText(filepath.Join(WORKSPACE, runID), "output.txt", outcome.Stdout); err != nil {
+		return "", err
 	}
-	return out.String(), nil
+	return "", nil
 }
 
-func cleanRunDirectory(username string) error {
-	targetPath := filepath.Join(WORKSPACE, username)
+func cleanRunDirectory(runID string) error {
+	targetPath := filepath.Join(WORKSPACE, runID)
 	return os.RemoveAll(targetPath)
 }
 
-func getOutputText(username string) (string, error) {
-	outputPath := filepath.Join(WORKSPACE, username, "output.txt")
+func getOutputText(runID string) (string, error) {
+	outputPath := filepath.Join(WORKSPACE, runID, "output.txt")
 	output, err := os.ReadFile(outputPath)
 	if err != nil {
 		return "", err
@@ -116,10 +142,10 @@ func getOutputText(username string) (string, error) {
 	return string(output), nil
 }
 
-func checkOutput(username string) (bool, string, string, string, error) {
-	outputPath := filepath.Join(WORKSPACE, username, "output.txt")
-	expectedPath := filepath.Join(WORKSPACE, username, "expected.txt")
-	inputPath := filepath.Join(WORKSPACE, username, "input.txt")
+func checkOutput(runID string) (bool, string, string, string, error) {
+	outputPath := filepath.Join(WORKSPACE, runID, "output.txt")
+	expectedPath := filepath.Join(WORKSPACE, runID, "expected.txt")
+	inputPath := filepath.Join(WORKSPACE, runID, "input.txt")
 
 	outputContent, err := os.ReadFile(outputPath)
 	if err != nil {
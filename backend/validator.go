@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TestDataError reports a test input rejected by the problem's validator
+type TestDataError struct {
+	Index   int    // 1-based test index
+	Message string // Whatever the validator wrote to stderr
+}
+
+func (e *TestDataError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("test %d violates the problem constraints", e.Index)
+	}
+	return fmt.Sprintf("test %d violates the problem constraints: %s", e.Index, e.Message)
+}
+
+// testInputs returns the raw stdin for every test case of a problem.
+// Function-based problems feed each test's "input" object as JSON,
+// IO-based problems feed each test's stdin.
+func testInputs(problem Problem) ([]string, error) {
+	if problem.SignatureJSON == "" {
+		tests, err := ioTests(problem)
+		if err != nil {
+			return nil, err
+		}
+		inputs := make([]string, len(tests))
+		for i, test := range tests {
+			inputs[i] = test.Input
+		}
+		return inputs, nil
+	}
+
+	var testCases []map[string]interface{}
+	if err := json.Unmarshal([]byte(problem.TestCasesJSON), &testCases); err != nil {
+		return nil, fmt.Errorf("invalid test cases: %v", err)
+	}
+
+	inputs := make([]string, 0, len(testCases))
+	for _, tc := range testCases {
+		inBytes, err := json.Marshal(tc["input"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid test input: %v", err)
+		}
+		inputs = append(inputs, string(inBytes))
+	}
+	return inputs, nil
+}
+
+// ValidateTestInputs runs the problem's validator against every test input.
+// The validator reads one input from stdin and exits non-zero if it breaks
+// the statement constraints. A *TestDataError is returned for the first
+// rejected input; any other error means the validator could not be run.
+func ValidateTestInputs(ctx context.Context, problem Problem) error {
+	if problem.Validator == "" {
+		return nil
+	}
+
+	inputs, err := testInputs(problem)
+	if err != nil {
+		return err
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(WORKSPACE, 0755); err != nil {
+		return err
+	}
+	runPath, err := os.MkdirTemp(WORKSPACE, "validate-")
+	if err != nil {
+		return fmt.Errorf("workspace error: %v", err)
+	}
+	defer os.RemoveAll(runPath)
+	// MkdirTemp makes it private to us, but the validator runs as the sandbox user
+	if err := os.Chmod(runPath, 0755); err != nil {
+		return fmt.Errorf("workspace error: %v", err)
+	}
+
+	if err := createFileFromText(runPath, "validator.py", problem.Validator); err != nil {
+		return fmt.Errorf("failed to write validator: %v", err)
+	}
+	for i, input := range inputs {
+		if err := createFileFromText(runPath, "input-"+strconv.Itoa(i+1)+".txt", input); err != nil {
+			return fmt.Errorf("failed to write test input: %v", err)
+		}
+	}
+
+	// All inputs are checked in one run; the index of the first rejected
+	// input is echoed on stdout before bailing out with status 3.
+	script := fmt.Sprintf(
+		"for i in $(seq 1 %d); do timeout 5s python validator.py < input-$i.txt > /dev/null || { echo $i; exit 3; }; done",
+		len(inputs),
+	)
+	limits := DefaultLimits
+	limits.WallTime = time.Duration(len(inputs)) * 5 * time.Second
+	limits.CPUTime = limits.WallTime
+
+	// Validators are written in Python
+	python, err := GetLanguage("python")
+	if err != nil {
+		return err
+	}
+	outcome, err := Sandbox.Run(ctx, RunSpec{
+		WorkDir: runPath,
+		Command: script,
+		Image:   python.Image,
+		RootFS:  python.RootFS,
+		Limits:  limits,
+	})
+	if err != nil {
+		return fmt.Errorf("validator error: %v", err)
+	}
+	if outcome.ExitCode == 3 {
+		index, convErr := strconv.Atoi(strings.TrimSpace(outcome.Stdout))
+		if convErr != nil {
+			return fmt.Errorf("validator error: unexpected output %q", outcome.Stdout)
+		}
+		return &TestDataError{Index: index, Message: strings.TrimSpace(outcome.Stderr)}
+	}
+	if outcome.TimedOut || outcome.ExitCode != 0 {
+		return fmt.Errorf("validator error: exit status %d, stderr: %s", outcome.ExitCode, outcome.Stderr)
+	}
+	return nil
+}
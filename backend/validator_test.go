@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestValidateTestInputs(t *testing.T) {
+	previous := Languages
+	Languages = []Language{{ID: "python", SourceFile: "solution.py", RunCommand: "python solution.py"}}
+	t.Cleanup(func() { Languages = previous })
+
+	var mode os.FileMode
+	useExecutor(t, &fakeExecutor{run: func(spec RunSpec) RunOutcome {
+		info, err := os.Stat(spec.WorkDir)
+		if err == nil {
+			mode = info.Mode().Perm()
+		}
+		// Reject the second input the way the validation script does
+		return RunOutcome{ExitCode: 3, Stdout: "2\n", Stderr: "n out of range\n"}
+	}})
+
+	problem := testIOProblem
+	problem.Validator = "import sys\n"
+	err := ValidateTestInputs(context.Background(), problem)
+	var dataErr *TestDataError
+	if !errors.As(err, &dataErr) || dataErr.Index != 2 || dataErr.Message != "n out of range" {
+		t.Errorf("error %v, want test 2 rejected", err)
+	}
+	if mode != 0755 {
+		t.Errorf("run directory mode %v, want 0755 so the sandbox user can read it", mode)
+	}
+}
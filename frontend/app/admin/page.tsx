@@ -6,6 +6,7 @@ import { Card } from "@/components/ui/Card";
 import { Input } from "@/components/ui/Input";
 import { Button } from "@/components/ui/Button";
 import { Badge } from "@/components/ui/Badge";
+import { authHeaders, fetchAllPages } from "@/lib/utils";
 import { ChevronLeft, Save, Plus, Calendar, Trophy, Users, Trash2, PlusCircle } from "lucide-react";
 
 export default function AdminPage() {
@@ -52,8 +53,8 @@ export default function AdminPage() {
 
   useEffect(() => {
     // Check Auth
-    const user = localStorage.getItem("codejudge_user");
-    if (user !== "admin") {
+    const role = localStorage.getItem("codejudge_role");
+    if (!role || role === "contestant") {
       router.push("/competitions");
     } else {
       setAuthorized(true);
@@ -66,11 +67,7 @@ export default function AdminPage() {
   const fetchUsers = async () => {
     try {
       const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || "/api";
-      const res = await fetch(`${backendUrl}/users`);
-      if (res.ok) {
-        const data = await res.json();
-        setUsers(Array.isArray(data) ? data : []);
-      }
+      setUsers(await fetchAllPages(`${backendUrl}/users`, { headers: authHeaders() }));
     } catch (err) {
       console.error("Failed to fetch users", err);
     }
@@ -79,11 +76,7 @@ export default function AdminPage() {
   const fetchContests = async () => {
     try {
       const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || "/api";
-      const res = await fetch(`${backendUrl}/contests`);
-      if (res.ok) {
-        const data = await res.json();
-        setContests(Array.isArray(data) ? data : []);
-      }
+      setContests(await fetchAllPages(`${backendUrl}/contests`));
     } catch (err) {
       console.error("Failed to fetch contests", err);
     }
@@ -92,12 +85,8 @@ export default function AdminPage() {
   const fetchRegistrations = async (contestId: number) => {
       try {
         const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || "/api";
-        const res = await fetch(`${backendUrl}/contest/${contestId}/registrations`);
-        if (res.ok) {
-            const data = await res.json();
-            setRegistrations(Array.isArray(data) ? data : []);
-            setViewingRegistrationsContestId(contestId);
-        }
+        setRegistrations(await fetchAllPages(`${backendUrl}/contest/${contestId}/registrations`, { headers: authHeaders() }));
+        setViewingRegistrationsContestId(contestId);
       } catch (err) {
           console.error(err);
       }
@@ -106,11 +95,7 @@ export default function AdminPage() {
   const fetchProblems = async () => {
     try {
       const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || "/api";
-      const res = await fetch(`${backendUrl}/problems`);
-      if (res.ok) {
-        const data = await res.json();
-        setProblems(Array.isArray(data) ? data : []);
-      }
+      setProblems(await fetchAllPages(`${backendUrl}/problems`));
     } catch (err) {
       console.error("Failed to fetch problems", err);
     }
@@ -167,7 +152,7 @@ export default function AdminPage() {
       
       const res = await fetch(`${backendUrl}/problem`, {
         method: method,
-        headers: { "Content-Type": "application/json" },
+        headers: { "Content-Type": "application/json", ...authHeaders() },
         body: JSON.stringify(body),
       });
 
@@ -200,6 +185,7 @@ export default function AdminPage() {
         const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || "/api";
         const res = await fetch(`${backendUrl}/problem/${id}`, {
             method: "DELETE",
+            headers: authHeaders(),
         });
         if (res.ok) {
             alert("Problem deleted successfully!");
@@ -232,6 +218,7 @@ export default function AdminPage() {
         const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || "/api";
         const res = await fetch(`${backendUrl}/contest/${id}`, {
             method: "DELETE",
+            headers: authHeaders(),
         });
         if (res.ok) {
             alert("Contest deleted successfully!");
@@ -294,7 +281,7 @@ export default function AdminPage() {
 
       const res = await fetch(`${backendUrl}/contest`, {
         method: method,
-        headers: { "Content-Type": "application/json" },
+        headers: { "Content-Type": "application/json", ...authHeaders() },
         body: JSON.stringify(body),
       });
       if (res.ok) {
@@ -6,6 +6,7 @@ import { useRouter } from "next/navigation";
 import { Card } from "@/components/ui/Card";
 import { Button } from "@/components/ui/Button";
 import { Badge } from "@/components/ui/Badge";
+import { fetchAllPages } from "@/lib/utils";
 // import { competitions, problems } from "@/lib/data"; // Removed static data
 import { Trophy, Timer, Users, ArrowRight, Code } from "lucide-react";
 
@@ -18,7 +19,8 @@ export default function CompetitionsPage() {
   
   useEffect(() => {
     const user = localStorage.getItem("codejudge_user");
-    setIsAdmin(user === "admin");
+    const role = localStorage.getItem("codejudge_role");
+    setIsAdmin(!!role && role !== "contestant");
     setUsername(user);
     
     fetchContests();
@@ -28,23 +30,16 @@ export default function CompetitionsPage() {
   const fetchContests = async () => {
     try {
         const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || "/api";
-        const res = await fetch(`${backendUrl}/contests`);
-        if (res.ok) {
-            const data = await res.json();
-            if (Array.isArray(data)) {
-                const formatted = data.map((c: any) => ({
-                    id: c.id,
-                    title: c.title,
-                    description: c.description,
-                    startTime: new Date(c.start_time).toLocaleString(),
-                    status: new Date() < new Date(c.start_time) ? 'upcoming' : new Date() > new Date(c.end_time) ? 'ended' : 'active',
-                    participants: c.participants || 0
-                }));
-                setContests(formatted);
-            } else {
-                setContests([]);
-            }
-        }
+        const data = await fetchAllPages(`${backendUrl}/contests`);
+        const formatted = data.map((c: any) => ({
+            id: c.id,
+            title: c.title,
+            description: c.description,
+            startTime: new Date(c.start_time).toLocaleString(),
+            status: new Date() < new Date(c.start_time) ? 'upcoming' : new Date() > new Date(c.end_time) ? 'ended' : 'active',
+            participants: c.participants || 0
+        }));
+        setContests(formatted);
     } catch (err) {
         console.error("Failed to fetch contests", err);
     }
@@ -53,15 +48,7 @@ export default function CompetitionsPage() {
   const fetchProblems = async () => {
     try {
         const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || "/api";
-        const res = await fetch(`${backendUrl}/problems/practice`);
-        if (res.ok) {
-            const data = await res.json();
-            if (Array.isArray(data)) {
-                setProblems(data);
-            } else {
-                setProblems([]);
-            }
-        }
+        setProblems(await fetchAllPages(`${backendUrl}/problems/practice?limit=200`));
     } catch (err) {
         console.error("Failed to fetch problems", err);
     }
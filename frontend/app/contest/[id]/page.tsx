@@ -7,6 +7,7 @@ import { Card } from "@/components/ui/Card";
 import { Button } from "@/components/ui/Button";
 import { Badge } from "@/components/ui/Badge";
 import { Timer, Users, ChevronLeft, ArrowRight, Play } from "lucide-react";
+import { authHeaders } from "@/lib/utils";
 
 export default function ContestPage() {
   const { id } = useParams();
@@ -74,7 +75,7 @@ export default function ContestPage() {
         if (!user || !id) return;
         try {
             const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || "/api";
-            const res = await fetch(`${backendUrl}/contest/status?user_id=${user}&contest_id=${id}`);
+            const res = await fetch(`${backendUrl}/contest/status?contest_id=${id}`, { headers: authHeaders() });
             if (res.ok) {
                 const data = await res.json();
                 setRegistered(data.registered);
@@ -141,9 +142,8 @@ export default function ContestPage() {
         const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || "/api";
         const res = await fetch(`${backendUrl}/contest/register`, {
             method: "POST",
-            headers: { "Content-Type": "application/json" },
+            headers: { "Content-Type": "application/json", ...authHeaders() },
             body: JSON.stringify({ 
-                user_id: username, 
                 contest_id: Number(id),
                 extra_info: submissionInfo
             })
@@ -8,7 +8,6 @@ import { Card } from "@/components/ui/Card";
 import { Input } from "@/components/ui/Input";
 import { Button } from "@/components/ui/Button";
 import { Terminal, Code2, Lock } from "lucide-react";
-import { verifyAdmin } from "./actions";
 
 export default function LoginPage() {
   const router = useRouter();
@@ -23,31 +22,24 @@ export default function LoginPage() {
     setError(null);
 
     try {
-      // 1. Check Env-based Admin Auth
-      const isAdmin = await verifyAdmin(username, password);
-      if (isAdmin) {
-        localStorage.setItem("codejudge_user", "admin");
-        router.push("/competitions");
-        return;
-      }
-
-      // 2. Check Backend User Auth
       const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || "/api";
-      const res = await fetch(`${backendUrl}/user/exists`, {
+      const res = await fetch(`${backendUrl}/login`, {
         method: "POST",
         headers: { "Content-Type": "application/json" },
         body: JSON.stringify({ username, password }),
       });
 
+      if (res.status === 401) {
+        throw new Error("Invalid identity credentials");
+      }
       if (!res.ok) throw new Error("Connection failed");
 
       const data = await res.json();
-      if (!data.exists) {
-        throw new Error("Invalid identity credentials");
-      }
 
-      // Store username in localStorage for "session"
-      localStorage.setItem("codejudge_user", username);
+      // The token authenticates requests; the username is kept for display
+      localStorage.setItem("codejudge_token", data.token);
+      localStorage.setItem("codejudge_user", data.username);
+      localStorage.setItem("codejudge_role", data.role);
       router.push("/competitions");
     } catch (err: any) {
       setError(err.message);
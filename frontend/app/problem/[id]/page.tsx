@@ -8,7 +8,7 @@ import { Button } from "@/components/ui/Button";
 import { Card } from "@/components/ui/Card";
 import { Badge } from "@/components/ui/Badge";
 import { Play, Trophy, ChevronLeft, Loader2, CheckCircle, XCircle } from "lucide-react";
-import { cn } from "@/lib/utils";
+import { cn, authHeaders } from "@/lib/utils";
 
 export default function ProblemPage() {
   const { id } = useParams();
@@ -98,15 +98,12 @@ export default function ProblemPage() {
     setExecutionResult(null);
     setStatus("idle");
 
-    const currentUsername = username || "anonymous";
-
     try {
       const backendUrl = process.env.NEXT_PUBLIC_BACKEND_URL || "/api";
       const res = await fetch(`${backendUrl}/run`, {
         method: "POST",
-        headers: { "Content-Type": "application/json" },
+        headers: { "Content-Type": "application/json", ...authHeaders() },
         body: JSON.stringify({
-          username: currentUsername,
           problem: id,
           solution: code
         })
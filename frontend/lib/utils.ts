@@ -4,3 +4,24 @@ import { twMerge } from "tailwind-merge"
 export function cn(...inputs: ClassValue[]) {
   return twMerge(clsx(inputs))
 }
+
+// Session token issued by POST /login, sent with every user-scoped request
+export function authHeaders(): Record<string, string> {
+  const token = typeof window !== "undefined" ? localStorage.getItem("codejudge_token") : null
+  return token ? { Authorization: `Bearer ${token}` } : {}
+}
+
+// Fetches every page of a paged list endpoint, following X-Next-Cursor
+export async function fetchAllPages(url: string, init?: RequestInit): Promise<any[]> {
+  let items: any[] = []
+  let cursor: string | null = ""
+  while (cursor !== null) {
+    const separator = url.includes("?") ? "&" : "?"
+    const res: Response = await fetch(cursor ? `${url}${separator}cursor=${encodeURIComponent(cursor)}` : url, init)
+    if (!res.ok) throw new Error(`${url}: ${res.status}`)
+    const data = await res.json()
+    if (Array.isArray(data)) items = items.concat(data)
+    cursor = res.headers.get("X-Next-Cursor")
+  }
+  return items
+}